@@ -6,7 +6,7 @@ appname="cow"
 if [ "$(uname -m | grep -o arm)" = "arm" ]
 then
     arch="arm"
-    image="armhf/golang:1.6"
+    image="arm32v7/golang:alpine"
 else
     arch="x86"
     image="golang:alpine"
@@ -14,7 +14,8 @@ fi
 dockerimgname=$dockeruser"/"$appname"_"$arch
 echo "Using image: $image"
 echo "Compiling $appname for $arch..."
-sudo docker run --rm -it -v "$(pwd)":/gocode -e "GOPATH=/gocode" -w /gocode $image sh -c "CGO_ENABLED=0 go build -a --installsuffix cgo --ldflags=\"-s\" -o $appname"
+srcdir="/gocode/src/github.com/shubhamat/dgo/agentcow"
+sudo docker run --rm -it -v "$(pwd)":$srcdir -e "GOPATH=/gocode" -w $srcdir $image sh -c "CGO_ENABLED=0 go build -a --installsuffix cgo --ldflags=\"-s\" -o $appname"
 echo "Building $dockerimgname docker image..."
 sudo docker build  -f Dockerfile.scratch -t $dockerimgname .
 
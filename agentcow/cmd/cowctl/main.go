@@ -0,0 +1,241 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+
+/*
+ * cowctl controls a running cow through its CowAdmin RPCs.
+ *
+ *     cowctl [-cow host:port] status
+ *     cowctl running                  items being eaten, stragglers marked
+ *     cowctl pause | resume
+ *     cowctl forage on|off            steal from other cows or not
+ *     cowctl forageable on|off        let other cows steal from this cow or not
+ *     cowctl forage-policy max|random|latency
+ *     cowctl set <setting> <value>    e.g. set max-sow-sleep 2
+ *     cowctl evict | admit <host:port>
+ *     cowctl cancel <item ID>         withdraw an item, wherever it went from this cow
+ *     cowctl recur <schedule ID> <every> <duration>  sow an item of duration every period
+ *     cowctl stop-recurring <schedule ID>
+ *     cowctl jobs | job <job ID>      progress of the jobs submitted to the cow
+ *     cowctl chaos [<setting>=<value> ...]  e.g. chaos latency=50ms rpc-drop=0.1
+ *
+ * The cow serves CowAdmin on its -admin address, loopback by default, so
+ * cowctl runs on the host of the cow unless -admin says otherwise.
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/rpc"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shubhamat/dgo/agentcow/cow"
+)
+
+var cowaddr = flag.String("cow", cow.DefaultAdminAddr, "Address the cow serves CowAdmin on, see its -admin flag")
+var tlsCert = flag.String("tls-cert", "", "Certificate signed by the herd CA, when the herd uses TLS")
+var tlsKey = flag.String("tls-key", "", "Private key for -tls-cert")
+var tlsCA = flag.String("tls-ca", "", "CA certificate of the herd")
+var timeout = flag.Duration("timeout", 5*time.Second, "Timeout for connecting to the cow")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: cowctl [OPTIONS] status | running | pause | resume | forage on|off | forageable on|off |\n")
+	fmt.Fprintf(os.Stderr, "                      forage-policy <policy> | set <setting> <value> | evict <addr> | admit <addr> |\n")
+	fmt.Fprintf(os.Stderr, "                      cancel <id> | recur <id> <every> <duration> | stop-recurring <id> |\n")
+	fmt.Fprintf(os.Stderr, "                      jobs | job <id> |\n")
+	fmt.Fprintf(os.Stderr, "                      chaos [<setting>=<value> ...]\n")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+	}
+
+	tlsConfig, err := cow.LoadTLSConfig(*tlsCert, *tlsKey, *tlsCA)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error in loading TLS configuration\n%s\n", err)
+		os.Exit(1)
+	}
+	transport := &cow.HTTPTransport{TLS: tlsConfig, DialTimeout: *timeout}
+	client, err := transport.Dial(*cowaddr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	if err := run(client, args[0], args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(client *rpc.Client, command string, args []string) error {
+	none := new(cow.ArgsNotUsed)
+	want := map[string]int{
+		"status": 0, "running": 0, "pause": 0, "resume": 0, "forage": 1, "forageable": 1,
+		"forage-policy": 1, "set": 2, "evict": 1, "admit": 1, "cancel": 1,
+		"recur": 3, "stop-recurring": 1, "jobs": 0, "job": 1,
+	}
+	n, ok := want[command]
+	if !ok && command != "chaos" {
+		usage()
+	}
+	if ok && len(args) != n {
+		return fmt.Errorf("%s takes %d arguments", command, n)
+	}
+
+	switch command {
+	case "status":
+		var s cow.AdminStatus
+		if err := client.Call("CowAdmin.Status", none, &s); err != nil {
+			return err
+		}
+		printStatus(s)
+		return nil
+	case "running":
+		var running []cow.RunningItem
+		if err := client.Call("CowAdmin.Running", none, &running); err != nil {
+			return err
+		}
+		printRunning(running)
+		return nil
+	case "pause":
+		return client.Call("CowAdmin.Pause", none, none)
+	case "resume":
+		return client.Call("CowAdmin.Resume", none, none)
+	case "forage", "forageable":
+		on, err := onOff(args[0])
+		if err != nil {
+			return err
+		}
+		method := map[string]string{"forage": "CowAdmin.SetForaging", "forageable": "CowAdmin.SetForageable"}[command]
+		return client.Call(method, &on, none)
+	case "forage-policy":
+		return client.Call("CowAdmin.SetForagePolicy", &args[0], none)
+	case "set":
+		return client.Call("CowAdmin.Set", &cow.Setting{Name: args[0], Value: args[1]}, none)
+	case "chaos":
+		return chaos(client, args)
+	case "jobs":
+		var jobs []cow.JobStatus
+		if err := client.Call("CowAdmin.Jobs", none, &jobs); err != nil {
+			return err
+		}
+		printJobs(jobs)
+		return nil
+	case "job":
+		var job cow.JobStatus
+		if err := client.Call("CowAdmin.Job", &args[0], &job); err != nil {
+			return err
+		}
+		printJobs([]cow.JobStatus{job})
+		return nil
+	case "cancel":
+		var reply cow.CancelReply
+		if err := client.Call("CowAdmin.Cancel", &args[0], &reply); err != nil {
+			return err
+		}
+		switch reply.State {
+		case "":
+			return fmt.Errorf("%s not found", args[0])
+		case cow.CancelEaten:
+			return fmt.Errorf("%s was eaten already", args[0])
+		}
+		fmt.Printf("cancelled %s, %s on %s\n", args[0], reply.State, reply.Cow)
+		return nil
+	case "recur":
+		return recur(client, args[0], args[1], args[2])
+	case "stop-recurring":
+		return client.Call("CowAdmin.StopRecurring", &args[0], none)
+	case "evict":
+		return client.Call("CowAdmin.Evict", &args[0], none)
+	default:
+		return client.Call("CowAdmin.Admit", &args[0], none)
+	}
+}
+
+/* Print the faults injected by the cow, after changing the given ones */
+func chaos(client *rpc.Client, args []string) error {
+	none := new(cow.ArgsNotUsed)
+	var s cow.ChaosSettings
+	if err := client.Call("CowAdmin.GetChaos", none, &s); err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		for _, arg := range args {
+			name, value, _ := strings.Cut(arg, "=")
+			if err := s.Set(name, value); err != nil {
+				return err
+			}
+		}
+		if err := client.Call("CowAdmin.SetChaos", &s, none); err != nil {
+			return err
+		}
+	}
+	fmt.Println(s)
+	return nil
+}
+
+/* Sow an item of the given duration every period, starting now */
+func recur(client *rpc.Client, id string, every string, duration string) error {
+	period, err := time.ParseDuration(every)
+	if err != nil {
+		return err
+	}
+	units, err := strconv.Atoi(duration)
+	if err != nil {
+		return fmt.Errorf("duration: %s", err)
+	}
+	s := cow.Schedule{ID: id, Every: period, Item: cow.WorkItem{Duration: units}}
+	return client.Call("CowAdmin.Recur", &s, new(cow.ArgsNotUsed))
+}
+
+func onOff(s string) (bool, error) {
+	switch s {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("want on or off, not %q", s)
+}
+
+func printStatus(s cow.AdminStatus) {
+	fmt.Printf("cow:            %s\n", s.ID)
+	fmt.Printf("paused:         %t\n", s.Paused)
+	fmt.Printf("foraging:       %t\n", s.Foraging)
+	fmt.Printf("forageable:     %t\n", s.Forageable)
+	fmt.Printf("forage policy:  %s\n", s.ForagePolicy)
+	fmt.Printf("cows:           %s\n", strings.Join(s.Cows, " "))
+	fmt.Printf("evicted:        %s\n", strings.Join(s.Evicted, " "))
+	fmt.Printf("settings:       %s\n", strings.Join(s.Tunables, " "))
+}
+
+func printRunning(running []cow.RunningItem) {
+	for _, r := range running {
+		straggler := ""
+		if r.Straggler {
+			straggler = "straggler"
+		}
+		fmt.Printf("%-24s duration %-4d running %-12s %-9s %s\n", r.Item.ID, r.Item.Duration, r.Running.Round(time.Millisecond), straggler, r.Copy)
+	}
+}
+
+func printJobs(jobs []cow.JobStatus) {
+	for _, j := range jobs {
+		finished := "-"
+		if !j.Finished.IsZero() {
+			finished = j.Finished.Sub(j.Submitted).Round(time.Millisecond).String()
+		}
+		fmt.Printf("%-20s items %-5d queued %-5d running %-5d done %-5d failed %-5d cancelled %-5d took %s\n",
+			j.ID, j.Items, j.Queued, j.Running, j.Done, j.Failed, j.Cancelled, finished)
+	}
+}
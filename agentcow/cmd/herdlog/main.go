@@ -0,0 +1,193 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+
+/*
+ * herdlog merges the event logs (-event-log) of the cows of a herd into one
+ * herd-wide timeline.
+ *
+ *     herdlog [-o merged.jsonl] [-timeline imbalance.csv] [-interval 1s] cow1.jsonl cow2.jsonl ...
+ *
+ * The merged log has the events of all cows ordered by time. The timeline is
+ * a CSV with the queue length of every cow sampled every -interval, along with
+ * the max, min and mean queue length and the spread (max - min), ready to be
+ * plotted to see how imbalanced the herd was over time.
+ */
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/shubhamat/dgo/agentcow/cow"
+)
+
+var outfile = flag.String("o", "", "Output file for the merged event log, defaults to stdout")
+var timeline = flag.String("timeline", "", "Output file for the queue length timeline (CSV)")
+var interval = flag.Duration("interval", time.Second, "Sampling interval of the timeline")
+
+func main() {
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: herdlog [OPTIONS] eventlog...\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *interval <= 0 {
+		fmt.Fprintf(os.Stderr, "-interval should be positive\n")
+		os.Exit(1)
+	}
+
+	events, err := mergeEvents(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	out := os.Stdout
+	if *outfile != "" {
+		file, err := os.Create(*outfile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		out = file
+	}
+	if err := writeEvents(out, events); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *timeline != "" {
+		file, err := os.Create(*timeline)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		if err := writeTimeline(file, events, *interval); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
+
+/* Events of all the logs ordered by time, those at the same time in the order of the logs */
+func mergeEvents(filenames []string) ([]cow.Event, error) {
+	var events []cow.Event
+	for _, filename := range filenames {
+		e, err := readEvents(filename)
+		if err != nil {
+			return nil, fmt.Errorf("Error in reading %s\n%s", filename, err)
+		}
+		events = append(events, e...)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events, nil
+}
+
+func readEvents(filename string) ([]cow.Event, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []cow.Event
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e cow.Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+func writeEvents(w io.Writer, events []cow.Event) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+/*
+ * Write the queue length of every cow, sampled every interval since the
+ * first event. A cow's queue length is the one of its latest event.
+ */
+func writeTimeline(w io.Writer, events []cow.Event, interval time.Duration) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var cows []string
+	index := make(map[string]int)
+	for _, e := range events {
+		if _, ok := index[e.Cow]; !ok {
+			index[e.Cow] = len(cows)
+			cows = append(cows, e.Cow)
+		}
+	}
+
+	out := csv.NewWriter(w)
+	header := append([]string{"seconds"}, cows...)
+	out.Write(append(header, "max", "min", "mean", "spread"))
+
+	qlen := make([]int, len(cows))
+	seen := make([]bool, len(cows))
+	start := events[0].Time
+	end := events[len(events)-1].Time
+	next := 0
+	for t := start; !t.After(end.Add(interval)); t = t.Add(interval) {
+		for ; next < len(events) && !events[next].Time.After(t); next++ {
+			i := index[events[next].Cow]
+			qlen[i] = events[next].QueueLen
+			seen[i] = true
+		}
+
+		row := []string{strconv.FormatFloat(t.Sub(start).Seconds(), 'f', 3, 64)}
+		max, min, sum, n := 0, 0, 0, 0
+		for i := range cows {
+			if !seen[i] {
+				row = append(row, "")
+				continue
+			}
+			row = append(row, strconv.Itoa(qlen[i]))
+			if n == 0 || qlen[i] > max {
+				max = qlen[i]
+			}
+			if n == 0 || qlen[i] < min {
+				min = qlen[i]
+			}
+			sum += qlen[i]
+			n++
+		}
+		mean := 0.0
+		if n > 0 {
+			mean = float64(sum) / float64(n)
+		}
+		row = append(row, strconv.Itoa(max), strconv.Itoa(min), strconv.FormatFloat(mean, 'f', 2, 64), strconv.Itoa(max-min))
+		out.Write(row)
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,78 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/* Two logs interleaved in time merge into one ordered log and timeline */
+func TestMergeEvents(t *testing.T) {
+	dir := t.TempDir()
+	logs := map[string]string{
+		"cow1.jsonl": `{"Time":"2017-05-01T10:00:00Z","Cow":"cow1","Type":"enqueue","Item":"a","QueueLen":1}
+{"Time":"2017-05-01T10:00:02Z","Cow":"cow1","Type":"steal-out","Item":"a","Peer":"cow2","QueueLen":0}
+
+{"Time":"2017-05-01T10:00:03Z","Cow":"cow1","Type":"enqueue","Item":"b","QueueLen":1}
+`,
+		"cow2.jsonl": `{"Time":"2017-05-01T10:00:01Z","Cow":"cow2","Type":"enqueue","Item":"c","QueueLen":2}
+{"Time":"2017-05-01T10:00:02Z","Cow":"cow2","Type":"steal-in","Item":"a","Peer":"cow1","QueueLen":3}
+`,
+	}
+	var files []string
+	for _, name := range []string{"cow1.jsonl", "cow2.jsonl"} {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(logs[name]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+
+	events, err := mergeEvents(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for i, e := range events {
+		order = append(order, e.Cow+"/"+e.Type)
+		if i > 0 && e.Time.Before(events[i-1].Time) {
+			t.Errorf("event %d at %v before the one at %v", i, e.Time, events[i-1].Time)
+		}
+	}
+	/* At the same time, cow1's event comes first as its log came first */
+	want := "cow1/enqueue cow2/enqueue cow1/steal-out cow2/steal-in cow1/enqueue"
+	if got := strings.Join(order, " "); got != want {
+		t.Errorf("merged %s, want %s", got, want)
+	}
+
+	var out bytes.Buffer
+	if err := writeEvents(&out, events); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(out.String(), "\n"); n != 5 {
+		t.Errorf("wrote %d events, want 5", n)
+	}
+
+	var csv bytes.Buffer
+	if err := writeTimeline(&csv, events, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	wantCSV := `seconds,cow1,cow2,max,min,mean,spread
+0.000,1,,1,1,1.00,0
+1.000,1,2,2,1,1.50,1
+2.000,0,3,3,0,1.50,3
+3.000,1,3,3,1,2.00,2
+4.000,1,3,3,1,2.00,2
+`
+	if csv.String() != wantCSV {
+		t.Errorf("timeline\n%s\nwant\n%s", csv.String(), wantCSV)
+	}
+
+	if _, err := mergeEvents([]string{filepath.Join(dir, "missing.jsonl")}); err == nil {
+		t.Error("merged a missing log")
+	}
+}
@@ -0,0 +1,209 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shubhamat/dgo/agentcow/cow"
+)
+
+/*
+ * Configuration file.
+ *
+ * A JSON object whose keys are flag names without the leading dash, e.g.
+ *
+ *     {"iface": "eth0", "forage-policy": "random", "peers": ["10.0.0.2:1234"]}
+ *
+ * Arrays are joined with commas. Flags given on the command line override
+ * the file. On a SIGHUP the file is read again and the reloadable settings
+ * below take effect, other changes need a restart.
+ */
+
+/* Held while the reloadable settings are changed or used by sow */
+var reloadMutex sync.Mutex
+
+/* Flags given on the command line, never overridden by the file */
+var cmdlineFlags = make(map[string]bool)
+
+var reloadable = map[string]bool{
+	"log-level":         true,
+	"arrival":           true,
+	"duration-dist":     true,
+	"max-work-duration": true,
+	"max-work-cost":     true,
+	"max-sow-sleep":     true,
+	"burst-size":        true,
+	"burst-period":      true,
+	"forage-policy":     true,
+}
+
+func init() {
+	for _, subsys := range cow.Subsystems {
+		reloadable["log-"+subsys] = true
+	}
+}
+
+/*
+ * Set flags from the configuration file. On a reload only the reloadable
+ * flags are set, a warning is logged for changes to the others.
+ */
+func loadConfig(filename string, reload bool) error {
+	if !reload {
+		flag.Visit(func(f *flag.Flag) { cmdlineFlags[f.Name] = true })
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+
+	for name, v := range config {
+		f := flag.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("unknown setting %q", name)
+		}
+		value, err := configValue(v)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		if cmdlineFlags[name] {
+			continue
+		}
+		if reload && !reloadable[name] {
+			if value != f.Value.String() {
+				mycow.Log(cow.SubsysMoo).Warn("setting cannot be reloaded, restart the cow", "setting", name)
+			}
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+/* Flag value of a JSON value */
+func configValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		var values []string
+		for _, e := range v {
+			s, err := configValue(e)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, s)
+		}
+		return strings.Join(values, ","), nil
+	}
+	return "", errors.New("unsupported value")
+}
+
+/* Check the reloadable flags */
+func checkReloadable() error {
+	if !validArrival(*arrivalDist) {
+		return fmt.Errorf("Unknown arrival distribution:%s", *arrivalDist)
+	}
+	if !validDuration(*durationDist) {
+		return fmt.Errorf("Unknown duration distribution:%s", *durationDist)
+	}
+	if *arrivalDist == arrival_burst && (*burstSize < 1 || *burstPeriod < 0) {
+		return errors.New("-burst-size should be at least 1 and -burst-period cannot be negative")
+	}
+	if *maxWorkDuration < 0 || *maxWorkCost < 1 || *maxSowSleep < 1 {
+		return errors.New("-max-work-duration cannot be negative, -max-work-cost and -max-sow-sleep should be at least 1")
+	}
+	if err := cow.ValidForagePolicy(*foragePolicy); err != nil {
+		return err
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("Unknown log level:%s", *logLevel)
+	}
+	for subsys, l := range subsysLogLevels {
+		if *l != "" && level.UnmarshalText([]byte(*l)) != nil {
+			return fmt.Errorf("Unknown log level for %s:%s", subsys, *l)
+		}
+	}
+	return nil
+}
+
+/* Reload the configuration file, keeping the current settings if it is invalid */
+func reloadConfig() {
+	log := mycow.Log(cow.SubsysMoo)
+	if *configFile == "" {
+		log.Warn("no configuration file to reload")
+		return
+	}
+
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	old := make(map[string]string)
+	for name := range reloadable {
+		old[name] = flag.Lookup(name).Value.String()
+	}
+
+	err := loadConfig(*configFile, true)
+	if err == nil {
+		err = checkReloadable()
+	}
+	if err != nil {
+		for name, value := range old {
+			flag.Set(name, value)
+		}
+		log.Error("configuration not reloaded", "file", *configFile, "err", err)
+		return
+	}
+
+	logger.SetLevels(logLevels())
+	mycow.SetForagePolicy(*foragePolicy)
+	log.Info("reloaded configuration", "file", *configFile)
+}
+
+/* The reloadable settings, changed at runtime with cowctl set */
+func tunables() map[string]cow.Tunable {
+	t := make(map[string]cow.Tunable)
+	for name := range reloadable {
+		name := name
+		t[name] = func(value string) error {
+			reloadMutex.Lock()
+			defer reloadMutex.Unlock()
+			old := flag.Lookup(name).Value.String()
+			err := flag.Set(name, value)
+			if err == nil {
+				err = checkReloadable()
+			}
+			if err != nil {
+				flag.Set(name, old)
+				return err
+			}
+			if name == "forage-policy" {
+				mycow.SetForagePolicy(*foragePolicy)
+			} else {
+				logger.SetLevels(logLevels())
+			}
+			return nil
+		}
+	}
+	return t
+}
@@ -0,0 +1,83 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shubhamat/dgo/agentcow/cow"
+)
+
+/*
+ * The configuration file overrides the defaults and the command line
+ * overrides the file, also on a reload, which only changes the reloadable
+ * settings.
+ */
+func TestConfigPrecedence(t *testing.T) {
+	/* The same flags in a set of their own, so none counts as given for the next test */
+	commandLine := flag.CommandLine
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	commandLine.VisitAll(func(f *flag.Flag) { flag.CommandLine.Var(f.Value, f.Name, f.Usage) })
+	saved := make(map[string]string)
+	for _, name := range []string{"max-work-cost", "max-sow-sleep", "forage-policy", "iface", "peers"} {
+		saved[name] = flag.Lookup(name).Value.String()
+	}
+	defer func(c *cow.Cow) {
+		for name, value := range saved {
+			commandLine.Lookup(name).Value.Set(value)
+		}
+		for name := range cmdlineFlags {
+			delete(cmdlineFlags, name)
+		}
+		flag.CommandLine = commandLine
+		mycow = c
+	}(mycow)
+	mycow = cow.New(cow.Options{Logger: cow.DiscardLogger()})
+
+	file := filepath.Join(t.TempDir(), "cow.json")
+	write := func(config string) {
+		if err := os.WriteFile(file, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(when string, want map[string]string) {
+		for name, value := range want {
+			if got := flag.Lookup(name).Value.String(); got != value {
+				t.Errorf("%s: -%s is %q, want %q", when, name, got, value)
+			}
+		}
+	}
+
+	/* Given on the command line */
+	flag.Set("max-sow-sleep", "9")
+
+	write(`{"max-work-cost": 7, "max-sow-sleep": 4, "iface": "eth1", "peers": ["10.0.0.2:23432", "10.0.0.3:23432"]}`)
+	if err := loadConfig(file, false); err != nil {
+		t.Fatal(err)
+	}
+	check("load", map[string]string{
+		"max-work-cost": "7",
+		"max-sow-sleep": "9",
+		"forage-policy": cow.ForageMax,
+		"iface":         "eth1",
+		"peers":         "10.0.0.2:23432,10.0.0.3:23432",
+	})
+
+	write(`{"max-work-cost": 8, "max-sow-sleep": 5, "forage-policy": "random", "iface": "eth2"}`)
+	if err := loadConfig(file, true); err != nil {
+		t.Fatal(err)
+	}
+	check("reload", map[string]string{
+		"max-work-cost": "8",
+		"max-sow-sleep": "9",
+		"forage-policy": cow.ForageRandom,
+		"iface":         "eth1",
+	})
+
+	write(`{"no-such-flag": 1}`)
+	if err := loadConfig(file, true); err == nil {
+		t.Error("unknown setting loaded")
+	}
+}
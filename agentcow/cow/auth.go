@@ -0,0 +1,65 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+/*
+ * Authentication of the herd.
+ *
+ * RPC:        With a TLS config (-tls-cert, -tls-key and -tls-ca) every
+ *             cow presents its certificate and only talks to cows whose
+ *             certificate is signed by the herd CA (mutual TLS).
+ *
+ * Discovery:  With a secret (-herd-secret) every beacon is followed by an
+ *             HMAC-SHA256 of the beacon keyed by the secret. Beacons that do
+ *             not verify, or are too old, are dropped (see beacon.go).
+ */
+
+/*
+ * Load the cow's certificate and the herd CA, for use by HTTPTransport.
+ * Returns nil config if TLS is not enabled.
+ */
+func LoadTLSConfig(certfile, keyfile, cafile string) (*tls.Config, error) {
+	if certfile == "" && keyfile == "" && cafile == "" {
+		return nil, nil
+	}
+	if certfile == "" || keyfile == "" || cafile == "" {
+		return nil, errors.New("-tls-cert, -tls-key and -tls-ca should be used together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certfile, keyfile)
+	if err != nil {
+		return nil, err
+	}
+
+	pem, err := os.ReadFile(cafile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", cafile)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func beaconMAC(secret []byte, msg []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
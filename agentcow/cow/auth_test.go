@@ -0,0 +1,49 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+/* Only beacons carrying an HMAC with the herd secret, of an unchanged and recent beacon, are taken */
+func TestBeaconAuth(t *testing.T) {
+	secret := []byte("herd secret")
+	b := Beacon{Version: protoVersion, Herd: "herd", Cow: "cow1", Addr: "10.0.0.1:23432"}
+	signed := makeBeacon(b, secret)
+
+	tampered := append([]byte(nil), signed...)
+	tampered[len(beaconMagic)+2] ^= 1
+
+	/* Signed correctly, but sent long ago */
+	old := b
+	old.Sent = time.Now().Add(-2 * beaconMaxSkew).UnixNano()
+	body, _ := json.Marshal(old)
+	msg := append([]byte(beaconMagic), body...)
+	stale := append(msg, beaconMAC(secret, msg)...)
+
+	for _, tc := range []struct {
+		name   string
+		buf    []byte
+		secret []byte
+		ok     bool
+	}{
+		{"signed", signed, secret, true},
+		{"unsigned without secret", makeBeacon(b, nil), nil, true},
+		{"wrong key", makeBeacon(b, []byte("other secret")), secret, false},
+		{"unsigned", makeBeacon(b, nil), secret, false},
+		{"tampered", tampered, secret, false},
+		{"truncated", signed[:len(signed)-1], secret, false},
+		{"short", []byte("cow"), secret, false},
+		{"stale", stale, secret, false},
+	} {
+		got, err := parseBeacon(tc.buf, tc.secret)
+		if tc.ok && (err != nil || got.Cow != b.Cow || got.Addr != b.Addr) {
+			t.Errorf("%s: got %+v, %v", tc.name, got, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("%s: accepted %+v", tc.name, got)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"context"
+	"fmt"
+)
+
+/*
+ * Balancing modes.
+ *
+ * receiver:  an idle cow forages items from the cow with the most (default)
+ * sender:    a cow whose queue is longer than Options.ShedThreshold offers
+ *            items to the least loaded cow that is under the threshold, which
+ *            accepts them while its own queue is under the threshold
+ * symmetric: both
+ *
+ * Queue lengths of other cows are what wander last fetched.
+ */
+
+const (
+	BalanceReceiver  = "receiver"
+	BalanceSender    = "sender"
+	BalanceSymmetric = "symmetric"
+)
+
+const defShedThreshold = 10
+
+type OfferArgs struct {
+	Item WorkItem
+	From string /* RPC address of the cow shedding the item */
+}
+
+func ValidBalance(mode string) error {
+	switch mode {
+	case BalanceReceiver, BalanceSender, BalanceSymmetric:
+		return nil
+	}
+	return fmt.Errorf("unknown balance mode %q", mode)
+}
+
+func (c *Cow) forages() bool {
+	return c.opts.Balance != BalanceSender
+}
+
+func (c *Cow) sheds() bool {
+	return c.opts.Balance != BalanceReceiver
+}
+
+/*
+ * Shed load: offer items to other cows while the queue is over the
+ * threshold.
+ */
+func (c *Cow) shed(ctx context.Context) {
+	defer c.wg.Done()
+	log := c.Log(SubsysForage)
+	log.Info("launched shed thread", "threshold", c.opts.ShedThreshold)
+
+	for sleep(ctx, c.opts.WanderInterval) {
+		for c.queue.Len() > c.opts.ShedThreshold {
+			cowaddr, ok := c.leastLoaded()
+			if !ok || !c.offer(cowaddr) {
+				break
+			}
+		}
+	}
+}
+
+/* Alive cow with the shortest queue, as long as it is under the threshold */
+func (c *Cow) leastLoaded() (string, bool) {
+	c.peerMutex.Lock()
+	defer c.peerMutex.Unlock()
+	min, minaddr := c.opts.ShedThreshold, ""
+	for cowaddr, p := range c.peers {
+		if p.State == stateAlive && p.QueueLen < min {
+			min, minaddr = p.QueueLen, cowaddr
+		}
+	}
+	return minaddr, minaddr != ""
+}
+
+/* Offer the first item that can be handed out to a cow, false if it was not taken */
+func (c *Cow) offer(cowaddr string) bool {
+	work, ok := c.queue.Steal(c.ready)
+	if !ok {
+		return false
+	}
+	client, err := c.dial(cowaddr)
+	accepted := false
+	if err == nil {
+		err = client.Call("CowRPC.Offer", &OfferArgs{work, c.opts.Addr}, &accepted)
+		client.Close()
+	}
+	if err != nil || !accepted {
+		c.queue.Push(work)
+		/* Expect it to be as busy until wander hears otherwise */
+		c.peerMutex.Lock()
+		if p, ok := c.peers[cowaddr]; ok {
+			p.QueueLen = c.opts.ShedThreshold
+		}
+		c.peerMutex.Unlock()
+		return false
+	}
+
+	c.statsMutex.Lock()
+	c.stats.ShedOut++
+	c.statsMutex.Unlock()
+	c.sent(work.ID, cowaddr)
+	c.peerMutex.Lock()
+	if p, ok := c.peers[cowaddr]; ok {
+		p.QueueLen++
+	}
+	c.peerMutex.Unlock()
+	c.Log(SubsysForage).Debug("shed work", "id", work.ID, "to", cowaddr, "qlen", c.queue.Len())
+	c.event(EventShedOut, work.ID, cowaddr)
+	return true
+}
+
+/* Accept an item offered by another cow, if this cow is under the threshold and can eat it */
+func (c *Cow) accept(args *OfferArgs) bool {
+	if c.queue.Len() >= c.opts.ShedThreshold || !canRun(c.opts.Labels, args.Item) {
+		return false
+	}
+	work := args.Item
+	work.Origin = OriginRemote
+	/* The sender only offers items whose dependencies completed */
+	c.markCompleted(work.DependsOn...)
+	c.queue.Push(work)
+
+	c.statsMutex.Lock()
+	c.stats.ShedIn++
+	c.statsMutex.Unlock()
+	c.Log(SubsysForage).Info("accepted work", "id", work.ID, "from", args.From)
+	c.event(EventShedIn, work.ID, args.From)
+	return true
+}
@@ -0,0 +1,90 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+/*
+ * Discovery beacon.
+ *
+ * Every cow periodically broadcasts a beacon that identifies the herd it
+ * belongs to, the cow itself and where its RPC server can be reached.
+ * Cows from other herds, or speaking another protocol version, are ignored
+ * so that independent herds can share a LAN.
+ *
+ * On the wire a beacon is:
+ *     magic | JSON encoded Beacon [| HMAC-SHA256(magic | JSON) with a secret]
+ */
+
+const beaconMagic = "cow"
+
+/* Bump when the beacon or CowRPC changes incompatibly */
+const protoVersion = 3
+
+/* Large enough for any beacon we send */
+const maxBeaconSize = 1024
+
+/* Beacons older (or newer) than this are treated as replays */
+const beaconMaxSkew = 30 * time.Second
+
+type Beacon struct {
+	Version int
+	Herd    string /* herd ID */
+	Cow     string /* cow ID, unique within the herd */
+	Addr    string /* RPC address of the cow, host:port */
+	Sent    int64  /* unix time in ns */
+}
+
+func makeBeacon(b Beacon, secret []byte) []byte {
+	b.Sent = time.Now().UnixNano()
+	body, _ := json.Marshal(b)
+	msg := append([]byte(beaconMagic), body...)
+	if len(secret) == 0 {
+		return msg
+	}
+	return append(msg, beaconMAC(secret, msg)...)
+}
+
+/*
+ * Decode a received beacon, verifying it was sent by a member of the herd
+ * when a secret is used.
+ */
+func parseBeacon(buf []byte, secret []byte) (Beacon, error) {
+	var b Beacon
+
+	if len(secret) != 0 {
+		if len(buf) < sha256.Size {
+			return b, errors.New("beacon too short")
+		}
+		n := len(buf) - sha256.Size
+		if !hmac.Equal(buf[n:], beaconMAC(secret, buf[:n])) {
+			return b, errors.New("beacon not authenticated")
+		}
+		buf = buf[:n]
+	}
+
+	if len(buf) < len(beaconMagic) || string(buf[:len(beaconMagic)]) != beaconMagic {
+		return b, errors.New("not a beacon")
+	}
+	if err := json.Unmarshal(buf[len(beaconMagic):], &b); err != nil {
+		return b, err
+	}
+
+	if len(secret) != 0 {
+		skew := time.Since(time.Unix(0, b.Sent))
+		if skew > beaconMaxSkew || skew < -beaconMaxSkew {
+			return b, errors.New("stale beacon")
+		}
+	}
+	return b, nil
+}
+
+/* Was the beacon sent by a cow of this cow's herd, speaking its protocol */
+func (c *Cow) sameHerd(b Beacon) bool {
+	return b.Version == protoVersion && b.Herd == c.opts.Herd
+}
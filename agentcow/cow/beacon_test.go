@@ -0,0 +1,40 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"testing"
+)
+
+/* Beacons of other herds or protocol versions decode, but are ignored */
+func TestBeaconFilter(t *testing.T) {
+	c := New(Options{Herd: "herd", Logger: DiscardLogger()})
+	for _, tc := range []struct {
+		name   string
+		beacon Beacon
+		same   bool
+	}{
+		{"same herd", Beacon{Version: protoVersion, Herd: "herd", Cow: "cow1"}, true},
+		{"other herd", Beacon{Version: protoVersion, Herd: "other", Cow: "cow1"}, false},
+		{"no herd", Beacon{Version: protoVersion, Cow: "cow1"}, false},
+		{"older version", Beacon{Version: protoVersion - 1, Herd: "herd", Cow: "cow1"}, false},
+		{"newer version", Beacon{Version: protoVersion + 1, Herd: "herd", Cow: "cow1"}, false},
+	} {
+		b, err := parseBeacon(makeBeacon(tc.beacon, nil), nil)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if b.Herd != tc.beacon.Herd || b.Version != tc.beacon.Version || b.Cow != tc.beacon.Cow {
+			t.Errorf("%s: decoded %+v", tc.name, b)
+		}
+		if same := c.sameHerd(b); same != tc.same {
+			t.Errorf("%s: same herd %v, want %v", tc.name, same, tc.same)
+		}
+	}
+
+	for _, buf := range []string{"", "moo", `cow{"Version":`, `{"Version":3}`} {
+		if b, err := parseBeacon([]byte(buf), nil); err == nil {
+			t.Errorf("%q decoded as %+v", buf, b)
+		}
+	}
+}
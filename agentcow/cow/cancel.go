@@ -0,0 +1,187 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"container/heap"
+	"errors"
+	"time"
+)
+
+/*
+ * Cancellation of sown items.
+ *
+ * Cancel withdraws an item by ID from the cow that has it: it is taken
+ * off the work queue or the held items, or if it is being eaten the
+ * context passed to the executor is cancelled (and that of a speculative
+ * copy). Every cow remembers where the items it handed out went (stolen,
+ * shed or retried elsewhere), so a cow that no longer has the item passes
+ * the cancellation on along that history (CowRPC.Cancel).
+ *
+ * A cow remembers the IDs it cancelled: those it held or handed out, and
+ * those passed on by the cow that handed it the item, so that an item on
+ * its way to a cow while it was cancelled is dropped when it gets there.
+ * Cancelling an ID no cow knows leaves nothing behind. Both the history
+ * and the cancelled IDs are forgotten after Options.HistoryTTL, or once a
+ * cancelled item that arrived was dropped. Items that depend on a
+ * cancelled item stay blocked.
+ */
+
+/* Most cows a cancellation is passed on to, items rarely move more than twice */
+const MaxCancelHops = 8
+
+const (
+	CancelQueued  = "queued"
+	CancelHeld    = "held"
+	CancelRunning = "running"
+	CancelEaten   = "eaten" /* too late, the item was eaten */
+)
+
+var errCancelled = errors.New("cancelled")
+
+type CancelArgs struct {
+	ID     string
+	Hops   int    /* how often it may still be passed on */
+	From   string /* RPC address of the cow passing it on */
+	Handed bool   /* From handed the item to this cow */
+}
+
+type CancelReply struct {
+	Cow   string /* RPC address of the cow that had the item */
+	State string /* what the item was doing, one of the Cancel constants, empty if not found */
+}
+
+/* Cow an item was handed to */
+type sentItem struct {
+	to string /* RPC address */
+	at time.Time
+}
+
+/* Remember that an item was handed to another cow */
+func (c *Cow) sent(id string, cowaddr string) {
+	if id == "" {
+		return
+	}
+	c.cancelMutex.Lock()
+	c.sentTo[id] = sentItem{cowaddr, time.Now()}
+	c.cancelMutex.Unlock()
+}
+
+/* Was the item cancelled, it is forgotten as it is dropped */
+func (c *Cow) dropCancelled(id string) bool {
+	c.cancelMutex.Lock()
+	defer c.cancelMutex.Unlock()
+	if _, ok := c.cancelled[id]; !ok || id == "" {
+		return false
+	}
+	delete(c.cancelled, id)
+	return true
+}
+
+/* Withdraw the item with the given ID from whichever cow has it */
+func (c *Cow) Cancel(id string) CancelReply {
+	return c.withdraw(id, MaxCancelHops, false)
+}
+
+/* Withdraw an item, handed is set when it was passed on by the cow that handed it here */
+func (c *Cow) withdraw(id string, hops int, handed bool) CancelReply {
+	if id == "" {
+		return CancelReply{}
+	}
+	if c.Completed(id) {
+		return CancelReply{State: CancelEaten}
+	}
+	c.cancelMutex.Lock()
+	next, forwarded := c.sentTo[id]
+	if forwarded || handed {
+		c.cancelled[id] = time.Now()
+	}
+	c.cancelMutex.Unlock()
+
+	state := ""
+	if work, ok := c.queue.Pop(func(work WorkItem) bool { return work.ID == id }); ok {
+		state = CancelQueued
+		c.reportJob(work, JobCancelled)
+	} else if work, ok := c.cancelHeld(id); ok {
+		state = CancelHeld
+		c.reportJob(work, JobCancelled)
+	} else if other, ok := c.cancelRunning(id); ok {
+		state = CancelRunning
+		/* No hops left, so the copy does not pass it back */
+		if other != "" && hops > 0 {
+			c.passCancel(other, id, 0, false)
+		}
+	}
+	if state != "" {
+		c.cancelMutex.Lock()
+		c.cancelled[id] = time.Now()
+		c.cancelMutex.Unlock()
+		c.statsMutex.Lock()
+		c.stats.Cancelled++
+		c.statsMutex.Unlock()
+		c.Log(SubsysMoo).Info("cancelled work", "id", id, "state", state)
+		c.event(EventCancel, id, "")
+		return CancelReply{Cow: c.opts.Addr, State: state}
+	}
+
+	if !forwarded || hops <= 0 {
+		return CancelReply{}
+	}
+	return c.passCancel(next.to, id, hops-1, true)
+}
+
+/* Pass a cancellation on to the cow that has an item */
+func (c *Cow) passCancel(cowaddr string, id string, hops int, handed bool) CancelReply {
+	var reply CancelReply
+	client, err := c.dial(cowaddr)
+	if err != nil {
+		c.Log(SubsysMoo).Warn("cannot pass on cancellation", "id", id, "peer", cowaddr, "err", err)
+		return reply
+	}
+	client.Call("CowRPC.Cancel", &CancelArgs{ID: id, Hops: hops, From: c.opts.Addr, Handed: handed}, &reply)
+	client.Close()
+	return reply
+}
+
+func (c *Cow) cancelHeld(id string) (WorkItem, bool) {
+	c.holdMutex.Lock()
+	defer c.holdMutex.Unlock()
+	for i, work := range c.held {
+		if work.ID == id {
+			heap.Remove(&c.held, i)
+			return work, true
+		}
+	}
+	return WorkItem{}, false
+}
+
+/* Interrupt an item being eaten, returns the cow running its other copy */
+func (c *Cow) cancelRunning(id string) (string, bool) {
+	c.runningMutex.Lock()
+	defer c.runningMutex.Unlock()
+	r, ok := c.running[id]
+	if !ok {
+		return "", false
+	}
+	r.cancel(errCancelled)
+	return r.copy, true
+}
+
+func (t *CowRPC) Cancel(args *CancelArgs, reply *CancelReply) error {
+	if t.c.partitioned(args.From) {
+		return errChaosPartition
+	}
+	*reply = t.c.withdraw(args.ID, args.Hops, args.Handed)
+	return nil
+}
+
+/* Forget items handed out or cancelled before cutoff */
+func (c *Cow) forgetCancelled(cutoff time.Time) {
+	c.cancelMutex.Lock()
+	defer c.cancelMutex.Unlock()
+	forgetBefore(c.cancelled, cutoff)
+	for id, s := range c.sentTo {
+		if s.at.Before(cutoff) {
+			delete(c.sentTo, id)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/rpc"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Fault injection, for measuring how well a herd balances when things go
+ * wrong. All faults are off by default and can be changed while the cow
+ * runs (SetChaos, CowAdmin.SetChaos).
+ *
+ * Latency, Jitter:  added to every connection to another cow
+ * RPCDrop:          fraction of connections to other cows that fail
+ * BeaconDrop:       fraction of beacons not sent, and of beacons ignored
+ * Partition:        cows this cow cannot talk to, either way
+ * CrashEvery:       the eat thread crashes this often, losing the item it
+ *                   was eating, and restarts after CrashFor. The item is
+ *                   queued again as it was: the executor did not fail on
+ *                   it, so the crash is not a failed attempt (retry.go)
+ */
+
+type ChaosSettings struct {
+	Latency    time.Duration
+	Jitter     time.Duration
+	RPCDrop    float64
+	BeaconDrop float64
+	Partition  []string /* RPC addresses */
+	CrashEvery time.Duration
+	CrashFor   time.Duration
+}
+
+var errChaosDrop = errors.New("dropped by chaos")
+var errChaosPartition = errors.New("partitioned by chaos")
+var errChaosCrash = errors.New("eat crashed by chaos")
+
+/* Set a setting by name, the names are those of cowctl chaos */
+func (s *ChaosSettings) Set(name string, value string) error {
+	var err error
+	switch name {
+	case "latency":
+		s.Latency, err = time.ParseDuration(value)
+	case "jitter":
+		s.Jitter, err = time.ParseDuration(value)
+	case "rpc-drop":
+		s.RPCDrop, err = parseFraction(value)
+	case "beacon-drop":
+		s.BeaconDrop, err = parseFraction(value)
+	case "partition":
+		s.Partition = ParseLabels(value)
+	case "crash-every":
+		s.CrashEvery, err = time.ParseDuration(value)
+	case "crash-for":
+		s.CrashFor, err = time.ParseDuration(value)
+	default:
+		return fmt.Errorf("unknown chaos setting %q", name)
+	}
+	if err != nil {
+		return fmt.Errorf("chaos %s: %s", name, err)
+	}
+	return nil
+}
+
+/* Names of the settings, for Set and Get */
+var ChaosNames = []string{"latency", "jitter", "rpc-drop", "beacon-drop", "partition", "crash-every", "crash-for"}
+
+/* Get a setting by name, in the form Set takes */
+func (s ChaosSettings) Get(name string) string {
+	switch name {
+	case "latency":
+		return s.Latency.String()
+	case "jitter":
+		return s.Jitter.String()
+	case "rpc-drop":
+		return strconv.FormatFloat(s.RPCDrop, 'g', -1, 64)
+	case "beacon-drop":
+		return strconv.FormatFloat(s.BeaconDrop, 'g', -1, 64)
+	case "partition":
+		return strings.Join(s.Partition, ",")
+	case "crash-every":
+		return s.CrashEvery.String()
+	case "crash-for":
+		return s.CrashFor.String()
+	}
+	return ""
+}
+
+func (s ChaosSettings) String() string {
+	var settings []string
+	for _, name := range ChaosNames {
+		settings = append(settings, name+"="+s.Get(name))
+	}
+	return strings.Join(settings, " ")
+}
+
+func parseFraction(value string) (float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err == nil && (f < 0 || f > 1) {
+		err = errors.New("should be between 0 and 1")
+	}
+	return f, err
+}
+
+type chaos struct {
+	mutex    sync.Mutex
+	settings ChaosSettings
+
+	crashEat context.CancelCauseFunc /* of the running eat thread */
+	changed  chan struct{}           /* wakes crashEat when settings change */
+}
+
+func (c *Cow) Chaos() ChaosSettings {
+	c.chaos.mutex.Lock()
+	defer c.chaos.mutex.Unlock()
+	return c.chaos.settings
+}
+
+func (c *Cow) SetChaos(s ChaosSettings) {
+	c.chaos.mutex.Lock()
+	c.chaos.settings = s
+	c.chaos.mutex.Unlock()
+	select {
+	case c.chaos.changed <- struct{}{}:
+	default:
+	}
+	c.Log(SubsysMoo).Info("set chaos", "chaos", s.String())
+}
+
+func (c *Cow) partitioned(cowaddr string) bool {
+	for _, p := range c.Chaos().Partition {
+		if p == cowaddr {
+			return true
+		}
+	}
+	return false
+}
+
+/* Connect to another cow, through the faults */
+func (c *Cow) dial(cowaddr string) (*rpc.Client, error) {
+	s := c.Chaos()
+	if c.partitioned(cowaddr) {
+		return nil, errChaosPartition
+	}
+	if s.RPCDrop > 0 && rand.Float64() < s.RPCDrop {
+		return nil, errChaosDrop
+	}
+	if delay := s.Latency; delay > 0 || s.Jitter > 0 {
+		if s.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(s.Jitter)))
+		}
+		/* Stop does not wait out the latency */
+		if !sleep(c.ctx, delay) {
+			return nil, c.ctx.Err()
+		}
+	}
+	return c.opts.Transport.Dial(cowaddr)
+}
+
+func (c *Cow) dropBeacon() bool {
+	drop := c.Chaos().BeaconDrop
+	return drop > 0 && rand.Float64() < drop
+}
+
+/*
+ * Run the eat thread, restarting it after CrashFor whenever chaos crashes
+ * it, until ctx is cancelled.
+ */
+func (c *Cow) superviseEat(ctx context.Context) {
+	defer c.wg.Done()
+	for ctx.Err() == nil {
+		eatCtx, crash := context.WithCancelCause(ctx)
+		c.chaos.mutex.Lock()
+		c.chaos.crashEat = crash
+		c.chaos.mutex.Unlock()
+
+		c.eat(eatCtx)
+		crash(nil)
+		if ctx.Err() != nil {
+			return
+		}
+		crashFor := c.Chaos().CrashFor
+		c.Log(SubsysEat).Warn("crashed, restarting", "after", crashFor)
+		sleep(ctx, crashFor)
+	}
+}
+
+/* Crash the eat thread every CrashEvery, counted from when it was set */
+func (c *Cow) crashEat(ctx context.Context) {
+	defer c.wg.Done()
+	for {
+		every := c.Chaos().CrashEvery
+		if every <= 0 {
+			every = time.Second
+		}
+		timer := time.NewTimer(every)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-c.chaos.changed:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+		if c.Chaos().CrashEvery <= 0 {
+			continue
+		}
+		c.chaos.mutex.Lock()
+		if c.chaos.crashEat != nil {
+			c.chaos.crashEat(errChaosCrash)
+		}
+		c.chaos.mutex.Unlock()
+	}
+}
+
+/* Queue an item lost to a crash again, without backoff */
+func (c *Cow) requeueCrashed(work WorkItem) {
+	c.statsMutex.Lock()
+	c.stats.Crashed++
+	c.statsMutex.Unlock()
+	c.Log(SubsysEat).Warn("lost work to crash, queueing it again", "id", work.ID)
+	c.enqueue(work)
+	c.event(EventRetry, work.ID, "")
+}
+
+/* Did chaos crash the eat thread running with ctx */
+func crashed(ctx context.Context) bool {
+	return context.Cause(ctx) == errChaosCrash
+}
+
+func (a *CowAdmin) GetChaos(_ *ArgsNotUsed, reply *ChaosSettings) error {
+	*reply = a.c.Chaos()
+	return nil
+}
+
+func (a *CowAdmin) SetChaos(s *ChaosSettings, _ *ArgsNotUsed) error {
+	a.c.SetChaos(*s)
+	return nil
+}
@@ -0,0 +1,222 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+ * Runtime control of a cow, for live experiments on a running herd.
+ *
+ * Eating can be paused, foraging (stealing from others) and being foraged
+ * from can be turned off, the forage policy changed and a cow evicted from
+ * the herd. Evicted cows are not added again by discovery until they are
+ * admitted. Applications add their own settings with Options.Tunables,
+ * e.g. the sow rate of the cow binary.
+ *
+ * The same is served to other programs as the CowAdmin RPC service, see
+ * cmd/cowctl, along with the items being eaten, cancellation, recurring
+ * submissions and jobs.
+ * CowAdmin is not served next to CowRPC, where every cow that can reach
+ * this one could use it, but only on Options.Admin. The cow binary serves
+ * it on DefaultAdminAddr, which is loopback.
+ */
+
+/* Application setting, changed by CowAdmin.Set */
+type Tunable func(value string) error
+
+type AdminStatus struct {
+	ID           string
+	Paused       bool
+	Foraging     bool
+	Forageable   bool
+	ForagePolicy string
+	Cows         []string
+	Evicted      []string
+	Tunables     []string
+}
+
+type Setting struct {
+	Name  string
+	Value string
+}
+
+func (c *Cow) Pause() {
+	c.settingsMutex.Lock()
+	c.paused = true
+	c.settingsMutex.Unlock()
+	c.Log(SubsysMoo).Info("paused eating")
+}
+
+func (c *Cow) Resume() {
+	c.settingsMutex.Lock()
+	c.paused = false
+	c.settingsMutex.Unlock()
+	c.Log(SubsysMoo).Info("resumed eating")
+}
+
+func (c *Cow) Paused() bool {
+	c.settingsMutex.Lock()
+	defer c.settingsMutex.Unlock()
+	return c.paused
+}
+
+/* Turn foraging from other cows on or off */
+func (c *Cow) SetForaging(on bool) {
+	c.settingsMutex.Lock()
+	c.noForaging = !on
+	c.settingsMutex.Unlock()
+	c.Log(SubsysMoo).Info("set foraging", "on", on)
+}
+
+func (c *Cow) Foraging() bool {
+	c.settingsMutex.Lock()
+	defer c.settingsMutex.Unlock()
+	return !c.noForaging
+}
+
+/* Let other cows forage from this cow or not */
+func (c *Cow) SetForageable(on bool) {
+	c.settingsMutex.Lock()
+	c.notForageable = !on
+	c.settingsMutex.Unlock()
+	c.Log(SubsysMoo).Info("set forageable", "on", on)
+}
+
+func (c *Cow) Forageable() bool {
+	c.settingsMutex.Lock()
+	defer c.settingsMutex.Unlock()
+	return !c.notForageable
+}
+
+/* Drop a cow from the herd and keep it out */
+func (c *Cow) Evict(cowaddr string) {
+	c.settingsMutex.Lock()
+	c.evicted[cowaddr] = true
+	c.settingsMutex.Unlock()
+
+	c.dropCow(cowaddr)
+	c.Log(SubsysMoo).Info("evicted cow", "peer", cowaddr)
+}
+
+/* Let an evicted cow join again, when it is discovered next */
+func (c *Cow) Admit(cowaddr string) {
+	c.settingsMutex.Lock()
+	delete(c.evicted, cowaddr)
+	c.settingsMutex.Unlock()
+	c.Log(SubsysMoo).Info("admitted cow", "peer", cowaddr)
+}
+
+func (c *Cow) isEvicted(cowaddr string) bool {
+	c.settingsMutex.Lock()
+	defer c.settingsMutex.Unlock()
+	return c.evicted[cowaddr]
+}
+
+/* Change an application setting, see Options.Tunables */
+func (c *Cow) Set(name string, value string) error {
+	tunable, ok := c.opts.Tunables[name]
+	if !ok {
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	if err := tunable(value); err != nil {
+		return err
+	}
+	c.Log(SubsysMoo).Info("changed setting", "setting", name, "value", value)
+	return nil
+}
+
+func (c *Cow) AdminStatus() AdminStatus {
+	s := AdminStatus{
+		ID:           c.opts.ID,
+		Paused:       c.Paused(),
+		Foraging:     c.Foraging(),
+		Forageable:   c.Forageable(),
+		ForagePolicy: c.ForagePolicy(),
+		Cows:         c.herd.cows(),
+	}
+	c.settingsMutex.Lock()
+	for cowaddr := range c.evicted {
+		s.Evicted = append(s.Evicted, cowaddr)
+	}
+	c.settingsMutex.Unlock()
+	for name := range c.opts.Tunables {
+		s.Tunables = append(s.Tunables, name)
+	}
+	sort.Strings(s.Evicted)
+	sort.Strings(s.Tunables)
+	return s
+}
+
+/* RPCs served to administrators */
+type CowAdmin struct {
+	c *Cow
+}
+
+func (a *CowAdmin) Status(_ *ArgsNotUsed, reply *AdminStatus) error {
+	*reply = a.c.AdminStatus()
+	return nil
+}
+
+func (a *CowAdmin) Pause(_ *ArgsNotUsed, _ *ArgsNotUsed) error {
+	a.c.Pause()
+	return nil
+}
+
+func (a *CowAdmin) Resume(_ *ArgsNotUsed, _ *ArgsNotUsed) error {
+	a.c.Resume()
+	return nil
+}
+
+func (a *CowAdmin) SetForaging(on *bool, _ *ArgsNotUsed) error {
+	a.c.SetForaging(*on)
+	return nil
+}
+
+func (a *CowAdmin) SetForageable(on *bool, _ *ArgsNotUsed) error {
+	a.c.SetForageable(*on)
+	return nil
+}
+
+func (a *CowAdmin) SetForagePolicy(policy *string, _ *ArgsNotUsed) error {
+	return a.c.SetForagePolicy(*policy)
+}
+
+func (a *CowAdmin) Evict(cowaddr *string, _ *ArgsNotUsed) error {
+	a.c.Evict(*cowaddr)
+	return nil
+}
+
+func (a *CowAdmin) Admit(cowaddr *string, _ *ArgsNotUsed) error {
+	a.c.Admit(*cowaddr)
+	return nil
+}
+
+func (a *CowAdmin) Set(s *Setting, _ *ArgsNotUsed) error {
+	return a.c.Set(s.Name, s.Value)
+}
+
+func (a *CowAdmin) Running(_ *ArgsNotUsed, reply *[]RunningItem) error {
+	*reply = a.c.Running()
+	return nil
+}
+
+func (a *CowAdmin) Cancel(id *string, reply *CancelReply) error {
+	*reply = a.c.Cancel(*id)
+	return nil
+}
+
+func (a *CowAdmin) Jobs(_ *ArgsNotUsed, reply *[]JobStatus) error {
+	*reply = a.c.Jobs()
+	return nil
+}
+
+func (a *CowAdmin) Job(id *string, reply *JobStatus) error {
+	status, ok := a.c.Job(*id)
+	if !ok {
+		return fmt.Errorf("unknown job %s", *id)
+	}
+	*reply = status
+	return nil
+}
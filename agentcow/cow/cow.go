@@ -0,0 +1,722 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+
+/*
+ * Package cow implements a cow of the agentcow herd: a node that eats work
+ * items off its work queue and, when it runs out of work, forages items
+ * from the cows with the most work.
+ *
+ * A Cow is created with New and runs between Start and Stop. The work
+ * queue, the transport used to talk to other cows, the discovery of other
+ * cows and the executor that eats work items can all be replaced through
+ * Options, so that a cow can be embedded in other programs and several
+ * cows can run in one process.
+ */
+package cow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/rpc"
+	"os"
+	"sync"
+	"time"
+)
+
+type WorkItem struct {
+	Duration  int
+	Cost      int
+	Origin    int
+	Requires  []string /* labels a cow needs to eat this item */
+	ID        string   /* set by whoever sows the item, identifies it across the herd */
+	DependsOn []string /* IDs of items that must be eaten first, see deps.go */
+
+	Attempts    int /* failed attempts so far, see retry.go */
+	MaxAttempts int /* 0 means Options.MaxAttempts */
+
+	NotBefore time.Time /* held back until then, see schedule.go */
+
+	Tenant string /* who submitted the item, see fairqueue.go */
+
+	Job    string /* job the item belongs to, see job.go */
+	JobCow string /* RPC address of the cow tracking the job */
+}
+
+/* A zero WorkItem is returned over RPC when there is no work */
+func (w WorkItem) empty() bool {
+	return w.Origin == 0
+}
+
+const (
+	OriginLocal  = 1
+	OriginRemote = 2
+)
+
+const DefaultPort = ":23432"
+
+/* Where the cow binary serves CowAdmin, see control.go */
+const DefaultAdminAddr = "127.0.0.1:23433"
+const DefaultHerd = "herd"
+
+const defWanderInterval = time.Second
+const defAnnounceInterval = time.Second
+const defIdleSleep = 100 * time.Millisecond
+
+/* Herd table entries not updated in this many wander rounds are stale */
+const staleWanders = 3
+
+type Options struct {
+	ID     string   /* unique within the herd, defaults to hostname:pid */
+	Herd   string   /* cows only join cows of the same herd, defaults to DefaultHerd */
+	Addr   string   /* RPC address other cows reach this cow at, advertised in beacons */
+	Labels []string /* capability labels of this cow */
+	Peers  []string /* RPC addresses of cows known without discovery */
+
+	Queue     Queue     /* defaults to NewDequeQueue() */
+	Transport Transport /* defaults to an HTTPTransport listening on DefaultPort */
+	Admin     Transport /* serves CowAdmin, nil means it is not served, see control.go */
+	Discovery Discovery /* nil means only Peers are in the herd */
+	Executor  Executor  /* defaults to SleepExecutor */
+
+	Balance          string        /* see balance.go, defaults to BalanceReceiver */
+	ShedThreshold    int           /* queue length above which a sender sheds items */
+	ForagePolicy     string        /* see policy.go, defaults to ForageMax */
+	ViewSize         int           /* most cows known at once, 0 means all, see view.go */
+	ShuffleInterval  time.Duration /* how often views are swapped, with ViewSize */
+	MaxHops          int           /* how often a steal request is forwarded, -1 means never */
+	WanderInterval   time.Duration /* how often the queue length of other cows is fetched */
+	AnnounceInterval time.Duration /* how often the beacon is sent */
+	IdleSleep        time.Duration /* how long eat waits when there is no work */
+
+	MaxAttempts    int           /* attempts before an item is dead, defaults to 3 */
+	RetryBackoff   time.Duration /* wait before the first retry, doubled for every retry */
+	RetryElsewhere bool          /* hand retries to another cow */
+
+	Speculate    float64       /* copy items running this many times their Duration, 0 means never, see speculate.go */
+	DurationUnit time.Duration /* of WorkItem.Duration, defaults to a second as SleepExecutor takes */
+
+	HistoryTTL        time.Duration /* how long IDs of eaten, handed out and cancelled items, and finished jobs, are remembered, defaults to an hour */
+	DependencyTimeout time.Duration /* how long an item waits for its dependencies before it is given up on, defaults to HistoryTTL */
+
+	Chaos ChaosSettings /* faults to inject, none by default, see chaos.go */
+
+	/* Application settings that can be changed at runtime, see control.go */
+	Tunables map[string]Tunable
+
+	/* Called by eat when there is no work left, neither local nor foraged */
+	OnEmpty func()
+
+	/* Called when a job submitted to this cow finishes, see job.go */
+	OnJobDone func(JobStatus)
+
+	Logger *Logger /* defaults to info level text on os.Stdout */
+
+	EventLog io.Writer /* queue events are appended here as JSONL, see Event */
+}
+
+type Stats struct {
+	Local     int /* items sown locally that were eaten */
+	Remote    int /* items foraged from other cows that were eaten */
+	StolenIn  int /* items foraged from other cows */
+	StolenOut int /* items handed out to other cows */
+	Failed    int /* failed attempts at eating an item */
+	Crashed   int /* items lost to chaos crashes and queued again */
+	ShedOut   int /* items offered to other cows and accepted */
+	ShedIn    int /* items accepted from other cows */
+
+	Forwarded int /* steal requests forwarded that got an item */
+
+	Speculated int /* copies of other cows' stragglers started */
+	Overtaken  int /* items cancelled because another cow ate them first */
+	Cancelled  int /* items withdrawn with Cancel while queued, held or running */
+
+	AvgEat time.Duration /* moving average of the time taken to eat an item */
+}
+
+type Cow struct {
+	opts  Options
+	queue Queue
+
+	herd *herdTable /* other cows and what they have to steal, see herd.go */
+
+	peerMutex sync.Mutex
+	peers     map[string]*peerState /* what wander last heard from each cow, by address */
+
+	statsMutex sync.Mutex
+	stats      Stats
+
+	completedMutex sync.Mutex
+	completed      map[string]time.Time /* IDs of items eaten anywhere in the herd, when last needed */
+	awaited        map[string]time.Time /* IDs blocked items depend on, when last checked */
+	blocked        map[string]time.Time /* IDs of blocked items, since when */
+
+	retryMutex  sync.Mutex
+	deadLetters []DeadLetter
+
+	runningMutex  sync.Mutex
+	running       map[string]*runningItem /* items being eaten, by ID */
+	lastSpeculate time.Time
+
+	cancelMutex sync.Mutex
+	cancelled   map[string]time.Time /* IDs of items withdrawn, when, see cancel.go */
+	sentTo      map[string]sentItem  /* cow an item was handed to, by ID */
+
+	jobMutex sync.Mutex
+	jobs     map[string]*job /* jobs submitted to this cow, by ID */
+
+	holdMutex sync.Mutex
+	held      heldItems /* items not due yet */
+	holdWake  chan struct{}
+	schedules map[string]*Schedule
+
+	loggers map[string]*slog.Logger /* by subsystem */
+
+	/* Settings that can be changed while the cow runs */
+	settingsMutex sync.Mutex
+	foragePolicy  string
+	paused        bool
+	noForaging    bool
+	notForageable bool
+	evicted       map[string]bool /* cows kept out of the herd, by address */
+
+	chaos chaos
+
+	eventMutex sync.Mutex
+
+	lastForward time.Time /* of a steal request, see forageFar */
+
+	server      io.Closer
+	adminServer io.Closer /* nil without Options.Admin */
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+
+	/* Held by RPCs that add threads, so that none is added once Stop waits */
+	stopMutex sync.RWMutex
+}
+
+/* Create a cow, filling in defaults for options that are not set */
+func New(opts Options) *Cow {
+	if opts.ID == "" {
+		hostname, _ := os.Hostname()
+		opts.ID = fmt.Sprintf("%s:%d", hostname, os.Getpid())
+	}
+	if opts.Herd == "" {
+		opts.Herd = DefaultHerd
+	}
+	if opts.Queue == nil {
+		opts.Queue = NewDequeQueue()
+	}
+	if opts.Transport == nil {
+		opts.Transport = &HTTPTransport{Listen: DefaultPort}
+	}
+	if opts.Executor == nil {
+		opts.Executor = SleepExecutor
+	}
+	if opts.Balance == "" {
+		opts.Balance = BalanceReceiver
+	}
+	if opts.ShedThreshold == 0 {
+		opts.ShedThreshold = defShedThreshold
+	}
+	if opts.ShuffleInterval == 0 {
+		opts.ShuffleInterval = defShuffleWanders * opts.WanderInterval
+	}
+	if opts.MaxHops == 0 && opts.ViewSize > 0 {
+		opts.MaxHops = defMaxHops
+	}
+	if opts.ForagePolicy == "" {
+		opts.ForagePolicy = ForageMax
+	}
+	if opts.WanderInterval == 0 {
+		opts.WanderInterval = defWanderInterval
+	}
+	if opts.AnnounceInterval == 0 {
+		opts.AnnounceInterval = defAnnounceInterval
+	}
+	if opts.IdleSleep == 0 {
+		opts.IdleSleep = defIdleSleep
+	}
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = defMaxAttempts
+	}
+	if opts.RetryBackoff == 0 {
+		opts.RetryBackoff = defRetryBackoff
+	}
+	if opts.DurationUnit == 0 {
+		opts.DurationUnit = defDurationUnit
+	}
+	if opts.HistoryTTL == 0 {
+		opts.HistoryTTL = defHistoryTTL
+	}
+	if opts.DependencyTimeout == 0 {
+		opts.DependencyTimeout = opts.HistoryTTL
+	}
+	if opts.Logger == nil {
+		opts.Logger = NewLogger(LogConfig{})
+	}
+
+	c := &Cow{
+		opts:      opts,
+		queue:     opts.Queue,
+		herd:      newHerdTable(),
+		peers:     make(map[string]*peerState),
+		completed: make(map[string]time.Time),
+		awaited:   make(map[string]time.Time),
+		blocked:   make(map[string]time.Time),
+		running:   make(map[string]*runningItem),
+		cancelled: make(map[string]time.Time),
+		sentTo:    make(map[string]sentItem),
+		jobs:      make(map[string]*job),
+		holdWake:  make(chan struct{}, 1),
+		schedules: make(map[string]*Schedule),
+		loggers:   make(map[string]*slog.Logger),
+
+		foragePolicy: opts.ForagePolicy,
+		evicted:      make(map[string]bool),
+	}
+	c.chaos.settings = opts.Chaos
+	c.chaos.changed = make(chan struct{}, 1)
+	for _, subsys := range Subsystems {
+		c.loggers[subsys] = opts.Logger.Subsystem(subsys).With("cow", opts.ID)
+	}
+	return c
+}
+
+func (c *Cow) ID() string {
+	return c.opts.ID
+}
+
+/*
+ * Start the cow: serve RPCs from other cows, discover them and eat.
+ * The cow runs until Stop is called or ctx is cancelled.
+ */
+func (c *Cow) Start(ctx context.Context) error {
+	if c.cancel != nil {
+		return errors.New("cow already started")
+	}
+	if err := ValidForagePolicy(c.opts.ForagePolicy); err != nil {
+		return err
+	}
+	if err := ValidBalance(c.opts.Balance); err != nil {
+		return err
+	}
+
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("CowRPC", &CowRPC{c}); err != nil {
+		return err
+	}
+	adminSrv := rpc.NewServer()
+	if err := adminSrv.RegisterName("CowAdmin", &CowAdmin{c}); err != nil {
+		return err
+	}
+	c.registerDashboard()
+
+	/* RPCs may start cow threads, they run in ctx */
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.ctx = ctx
+	server, err := c.opts.Transport.Serve(srv)
+	if err != nil {
+		c.cancel()
+		c.cancel = nil
+		return err
+	}
+	c.server = server
+	c.Log(SubsysMoo).Info("serving RPC", "addr", c.opts.Addr)
+
+	if c.opts.Admin != nil {
+		c.adminServer, err = c.opts.Admin.Serve(adminSrv)
+		if err != nil {
+			c.server.Close()
+			c.cancel()
+			c.cancel = nil
+			return err
+		}
+	}
+
+	for _, peer := range c.opts.Peers {
+		c.addCow(ctx, peer, peer)
+	}
+
+	if c.opts.Discovery != nil {
+		c.wg.Add(2)
+		go c.discover(ctx)
+		go c.beDiscovered(ctx)
+	}
+
+	c.wg.Add(4)
+	go c.superviseEat(ctx)
+	go c.crashEat(ctx)
+	go c.release(ctx)
+	go c.forget(ctx)
+
+	if c.sheds() {
+		c.wg.Add(1)
+		go c.shed(ctx)
+	}
+
+	if c.opts.ViewSize > 0 {
+		c.wg.Add(1)
+		go c.shuffle(ctx)
+	}
+
+	/* Stop serving and discovering once cancelled, which unblocks discover */
+	go func() {
+		<-ctx.Done()
+		c.server.Close()
+		if c.adminServer != nil {
+			c.adminServer.Close()
+		}
+		if c.opts.Discovery != nil {
+			c.opts.Discovery.Close()
+		}
+	}()
+
+	return nil
+}
+
+/* Stop the cow and wait for all its threads to exit */
+func (c *Cow) Stop() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+	c.stopMutex.Lock()
+	c.stopMutex.Unlock()
+	c.holdMutex.Lock()
+	c.schedules = make(map[string]*Schedule)
+	c.holdMutex.Unlock()
+	c.wg.Wait()
+}
+
+/* Add a work item sown locally to the work queue, or hold it until NotBefore */
+func (c *Cow) Submit(work WorkItem) {
+	work.Origin = OriginLocal
+	c.enqueue(work)
+	c.event(EventEnqueue, work.ID, "")
+}
+
+func (c *Cow) QueueLen() int {
+	return c.queue.Len()
+}
+
+func (c *Cow) Stats() Stats {
+	c.statsMutex.Lock()
+	defer c.statsMutex.Unlock()
+	return c.stats
+}
+
+/* Logger of a subsystem, records are tagged with the cow's ID */
+func (c *Cow) Log(subsys string) *slog.Logger {
+	if l, ok := c.loggers[subsys]; ok {
+		return l
+	}
+	return c.opts.Logger.Subsystem(subsys).With("cow", c.opts.ID)
+}
+
+/* Sleep for d, returns false if ctx was cancelled meanwhile */
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+/*
+ * Discover new cows.
+ */
+func (c *Cow) discover(ctx context.Context) {
+	defer c.wg.Done()
+	log := c.Log(SubsysDiscover)
+	log.Info("launched thread")
+
+	/* Senders that have already been reported as ignored */
+	ignored := make(map[string]bool)
+
+	for {
+		beacon, newcowaddr, err := c.opts.Discovery.Receive()
+		if ctx.Err() != nil {
+			return
+		}
+		if berr, ok := err.(*BeaconError); ok {
+			if !ignored[berr.From] {
+				log.Warn("ignoring beacon", "from", berr.From, "err", berr.Err)
+				ignored[berr.From] = true
+			}
+			continue
+		}
+		if err != nil {
+			log.Error("read error", "err", err)
+			if !sleep(ctx, time.Second) {
+				return
+			}
+			continue
+		}
+		if beacon.Cow == c.opts.ID || c.dropBeacon() {
+			continue
+		}
+		if !c.sameHerd(beacon) {
+			if !ignored[beacon.Cow] {
+				log.Info("ignoring cow of another herd", "peer", beacon.Cow, "herd", beacon.Herd, "version", beacon.Version)
+				ignored[beacon.Cow] = true
+			}
+			continue
+		}
+
+		c.addCow(ctx, beacon.Cow, newcowaddr)
+	}
+}
+
+/* Add a cow to the herd, unless it is already known, evicted or the view is full */
+func (c *Cow) addCow(ctx context.Context, id string, cowaddr string) {
+	c.stopMutex.RLock()
+	defer c.stopMutex.RUnlock()
+	if ctx.Err() != nil || c.isEvicted(cowaddr) || c.viewFull() {
+		return
+	}
+	ctx, stop := context.WithCancel(ctx)
+	if !c.herd.add(cowaddr, stop) {
+		stop()
+		return
+	}
+
+	c.peerMutex.Lock()
+	c.peers[cowaddr] = &peerState{CowStats: CowStats{ID: id}, Addr: cowaddr, State: stateJoining}
+	c.peerMutex.Unlock()
+	c.event(EventPeerJoined, "", cowaddr)
+	c.Log(SubsysDiscover).Info("adding new cow", "peer", id, "addr", cowaddr, "herd", c.opts.Herd, "cows", 1+c.herd.len())
+	c.wg.Add(1)
+	go c.wander(ctx, cowaddr)
+}
+
+/*
+ * Let other cows know you exist
+ */
+func (c *Cow) beDiscovered(ctx context.Context) {
+	defer c.wg.Done()
+	log := c.Log(SubsysDiscover)
+	log.Info("launched bediscovered thread")
+
+	beacon := Beacon{protoVersion, c.opts.Herd, c.opts.ID, c.opts.Addr, 0}
+	for {
+		if !c.dropBeacon() {
+			if err := c.opts.Discovery.Announce(beacon); err != nil {
+				log.Error("announce error", "err", err)
+			}
+		}
+		if !sleep(ctx, c.opts.AnnounceInterval) {
+			return
+		}
+	}
+}
+
+/*
+ * Take the first item this cow can eat off the work queue.
+ * Items that need labels this cow does not have are left for other cows,
+ * blocked items are left until their dependencies complete.
+ */
+func (c *Cow) dequeue() (WorkItem, bool) {
+	work, ok := c.queue.Pop(c.canRun)
+	if !ok {
+		if c.forages() && c.Foraging() {
+			c.forage()
+		}
+		return work, ok
+	}
+	c.event(EventDequeue, work.ID, "")
+	return work, ok
+}
+
+func (c *Cow) canRun(work WorkItem) bool {
+	return canRun(c.opts.Labels, work) && c.ready(work)
+}
+
+/* Eat until ctx is cancelled, see superviseEat */
+func (c *Cow) eat(ctx context.Context) {
+	log := c.Log(SubsysEat)
+	log.Info("launched thread")
+
+	for ctx.Err() == nil {
+		if c.Paused() {
+			sleep(ctx, c.opts.IdleSleep)
+			continue
+		}
+		work, ok := c.dequeue()
+		if !ok {
+			if c.speculate(ctx) {
+				continue
+			}
+			if c.opts.OnEmpty != nil && c.queue.Len() == 0 && c.HeldLen() == 0 {
+				c.opts.OnEmpty()
+			}
+			sleep(ctx, c.opts.IdleSleep)
+			continue
+		}
+		c.eatItem(ctx, work, "")
+	}
+}
+
+/* Eat an item, or a copy of a straggler of owner, see speculate.go */
+func (c *Cow) eatItem(ctx context.Context, work WorkItem, owner string) {
+	log := c.Log(SubsysEat)
+	if c.dropCancelled(work.ID) {
+		log.Info("dropped cancelled work", "id", work.ID)
+		c.reportJob(work, JobCancelled)
+		return
+	}
+	c.statsMutex.Lock()
+	switch work.Origin {
+	case OriginLocal:
+		c.stats.Local++
+	case OriginRemote:
+		c.stats.Remote++
+	}
+	c.statsMutex.Unlock()
+	log.Info("processing work", "id", work.ID, "duration", work.Duration, "qlen", c.queue.Len())
+	c.event(EventStart, work.ID, "")
+	c.reportJob(work, JobRunning)
+
+	/* Cancelled with errOvertaken when another cow eats the item first */
+	itemCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	c.startRunning(work, cancel, owner)
+	start := time.Now()
+	err := c.opts.Executor.Execute(itemCtx, work)
+	other := c.stopRunning(work.ID)
+
+	switch {
+	case err == nil:
+		c.statsMutex.Lock()
+		c.stats.AvgEat = ewma(c.stats.AvgEat, time.Since(start))
+		c.statsMutex.Unlock()
+		c.markCompleted(work.ID)
+		c.reportJob(work, JobDone)
+		if other != "" {
+			c.wg.Add(1)
+			go c.notifyCompleted(ctx, other, work.ID)
+		}
+	case context.Cause(itemCtx) == errCancelled:
+		log.Info("cancelled work while eating", "id", work.ID)
+		c.reportJob(work, JobCancelled)
+	case context.Cause(itemCtx) == errOvertaken || (work.ID != "" && c.Completed(work.ID)):
+		c.statsMutex.Lock()
+		c.stats.Overtaken++
+		c.statsMutex.Unlock()
+		log.Info("item eaten elsewhere first", "id", work.ID, "by", other)
+		c.event(EventOvertaken, work.ID, other)
+	case owner != "":
+		log.Info("copy of straggler failed", "id", work.ID, "owner", owner, "err", err)
+	case ctx.Err() == nil:
+		c.failed(work, err)
+	case crashed(ctx):
+		c.requeueCrashed(work)
+	}
+	c.event(EventFinish, work.ID, "")
+}
+
+/*
+ * Wander and fetch the queue len for the given cow.
+ * One thread for each cow in the herd table, cowaddr is the cow's RPC
+ * address. It exits when the cow is removed, see herdTable.add.
+ */
+func (c *Cow) wander(ctx context.Context, cowaddr string) {
+	defer c.wg.Done()
+	log := c.Log(SubsysWander)
+	log.Info("launched thread", "peer", cowaddr)
+
+	for {
+		if ctx.Err() != nil {
+			log.Info("exiting thread", "peer", cowaddr)
+			return
+		}
+		/* The RTT covers connecting too, as every steal does */
+		seq, measured := c.herd.nextSeq(cowaddr), time.Now()
+		client, err := c.dial(cowaddr)
+		if err != nil {
+			log.Debug("cow unreachable", "peer", cowaddr, "err", err)
+			c.peerUnreachable(cowaddr)
+			sleep(ctx, 2*c.opts.WanderInterval)
+			continue
+		}
+		var entry herdEntry
+		err = client.Call("CowRPC.GetStealableLen", &StealArgs{Labels: c.opts.Labels, Thief: c.opts.Addr}, &entry.Stealable)
+		entry.RTT = time.Since(measured)
+
+		var stats CowStats
+		if client.Call("CowRPC.GetStats", &Caller{c.opts.Addr}, &stats) == nil {
+			c.setPeerState(cowaddr, stateAlive, &stats)
+			c.fetchCompleted(client, cowaddr)
+			entry.QueueLen, entry.AvgEat = stats.QueueLen, stats.AvgEat
+		} else {
+			c.peerUnreachable(cowaddr)
+		}
+		if err == nil {
+			c.herd.update(cowaddr, entry, measured, seq)
+			log.Debug("fetched queue length", "peer", cowaddr, "stealable", entry.Stealable, "rtt", entry.RTT)
+		}
+		client.Close()
+		sleep(ctx, c.opts.WanderInterval)
+	}
+}
+
+/* Herd table entries that are not stale, logs when all of them become stale */
+func (c *Cow) freshCows() []herdEntry {
+	cows, changed := c.herd.fresh(staleWanders)
+	switch {
+	case changed && len(cows) == 0:
+		c.Log(SubsysForage).Warn("every cow in the herd table is stale", "cows", c.herd.len(), "rounds", staleWanders)
+	case changed:
+		c.Log(SubsysForage).Info("herd table is fresh again", "cows", len(cows))
+	}
+	return cows
+}
+
+/* Mark a cow unreachable, it is lost if it was alive until now */
+func (c *Cow) peerUnreachable(cowaddr string) {
+	if c.setPeerState(cowaddr, stateUnreachable, nil) == stateAlive {
+		c.event(EventPeerLost, "", cowaddr)
+	}
+}
+
+/*
+ * Get work off another cow's queue
+ */
+func (c *Cow) forage() {
+	/* Ignore what wander has not heard from a cow lately */
+	cows := c.freshCows()
+	if len(cows) < 1 {
+		return
+	}
+
+	maxcowaddr, max, declined := c.pickVictim(cows)
+	if declined {
+		c.Log(SubsysForage).Debug("no cow is worth stealing from", "policy", c.ForagePolicy())
+		return
+	}
+
+	var work WorkItem
+	if max == 0 {
+		/* No cow this cow knows has items it can eat, ask further away */
+		work, maxcowaddr = c.forageFar(cows)
+	} else {
+		work = c.steal(maxcowaddr, 0, nil)
+	}
+
+	if !work.empty() {
+		c.statsMutex.Lock()
+		c.stats.StolenIn++
+		c.statsMutex.Unlock()
+		c.Log(SubsysForage).Info("added work", "id", work.ID, "from", maxcowaddr, "stealable", max)
+		work.Origin = OriginRemote
+		/* The victim only hands out items whose dependencies completed */
+		c.markCompleted(work.DependsOn...)
+		c.queue.Push(work)
+		c.event(EventStealIn, work.ID, maxcowaddr)
+	}
+}
@@ -0,0 +1,204 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+/*
+ * Herd dashboard.
+ *
+ * The moo HTTP server (HTTPTransport) serves a page that shows every cow
+ * this cow knows about, updated live with server-sent events:
+ *
+ *     /             the page
+ *     /herd         the herd as this cow sees it, in JSON
+ *     /herd/events  the same, pushed every second as server-sent events
+ *     /jobs         the jobs this cow tracks, /jobs/<id> one of them, see job.go
+ *
+ * Stats of other cows are what wander last fetched with CowRPC.GetStats.
+ */
+
+const (
+	stateSelf        = "self"
+	stateJoining     = "joining"
+	stateAlive       = "alive"
+	stateUnreachable = "unreachable"
+)
+
+const dashboardInterval = time.Second
+
+/* Stats of a cow, as served by CowRPC.GetStats */
+type CowStats struct {
+	ID       string
+	QueueLen int
+	Stats
+}
+
+/* A cow as seen by this cow */
+type peerState struct {
+	CowStats
+	Addr     string
+	State    string
+	LastSeen time.Time
+}
+
+type HerdView struct {
+	Cow  string /* ID of the cow this view is from */
+	Herd string
+	Time time.Time
+	Cows []peerState
+}
+
+func (c *Cow) cowStats() CowStats {
+	return CowStats{c.opts.ID, c.queue.Len(), c.Stats()}
+}
+
+/*
+ * Record the outcome of wandering to a cow, stats is nil if it could not
+ * be reached. Returns the previous state of the cow.
+ */
+func (c *Cow) setPeerState(cowaddr string, state string, stats *CowStats) string {
+	c.peerMutex.Lock()
+	defer c.peerMutex.Unlock()
+	p, ok := c.peers[cowaddr]
+	if !ok {
+		return ""
+	}
+	prev := p.State
+	p.State = state
+	if stats != nil {
+		p.CowStats = *stats
+		p.LastSeen = time.Now()
+	}
+	return prev
+}
+
+/* The herd as this cow sees it, starting with itself */
+func (c *Cow) Herd() HerdView {
+	view := HerdView{Cow: c.opts.ID, Herd: c.opts.Herd, Time: time.Now()}
+	view.Cows = append(view.Cows, peerState{c.cowStats(), c.opts.Addr, stateSelf, view.Time})
+
+	c.peerMutex.Lock()
+	for _, cowaddr := range c.herd.cows() {
+		if p, ok := c.peers[cowaddr]; ok {
+			view.Cows = append(view.Cows, *p)
+		}
+	}
+	c.peerMutex.Unlock()
+	return view
+}
+
+/* Register the dashboard with the transport, if it serves HTTP */
+func (c *Cow) registerDashboard() {
+	t, ok := c.opts.Transport.(interface {
+		Handle(pattern string, handler http.Handler)
+	})
+	if !ok {
+		return
+	}
+	t.Handle("/", http.HandlerFunc(c.serveDashboard))
+	t.Handle("/herd", http.HandlerFunc(c.serveHerd))
+	t.Handle("/herd/events", http.HandlerFunc(c.serveHerdEvents))
+	t.Handle("/jobs", http.HandlerFunc(c.serveJobs))
+	t.Handle("/jobs/", http.HandlerFunc(c.serveJobs))
+}
+
+func (c *Cow) serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, dashboardHTML)
+}
+
+func (c *Cow) serveHerd(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.Herd())
+}
+
+func (c *Cow) serveHerdEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ticker := time.NewTicker(dashboardInterval)
+	defer ticker.Stop()
+	for {
+		data, _ := json.Marshal(c.Herd())
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<title>agentcow herd</title>
+<style>
+body { font-family: monospace; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: right; }
+th:first-child, td:first-child, td.state { text-align: left; }
+tr.self { font-weight: bold; }
+tr.unreachable { color: #b00; }
+tr.joining { color: #888; }
+.bar { display: inline-block; height: 10px; background: #48a; }
+</style>
+</head>
+<body>
+<h2 id="title">agentcow herd</h2>
+<table>
+<thead><tr><th>cow</th><th>state</th><th>queue</th><th></th><th>eaten</th><th>local</th><th>remote</th><th>steals in</th><th>steals out</th><th>last seen</th></tr></thead>
+<tbody id="cows"></tbody>
+</table>
+<script>
+function cell(text, cls) {
+	var td = document.createElement("td");
+	td.textContent = text;
+	if (cls) td.className = cls;
+	return td;
+}
+var events = new EventSource("/herd/events");
+events.onmessage = function(e) {
+	var herd = JSON.parse(e.data);
+	document.getElementById("title").textContent = "herd " + herd.Herd + " as seen by " + herd.Cow;
+	var tbody = document.getElementById("cows");
+	tbody.innerHTML = "";
+	herd.Cows.forEach(function(c) {
+		var tr = document.createElement("tr");
+		tr.className = c.State;
+		tr.appendChild(cell(c.ID + " (" + c.Addr + ")"));
+		tr.appendChild(cell(c.State, "state"));
+		tr.appendChild(cell(c.QueueLen));
+		var bar = cell("");
+		bar.innerHTML = '<span class="bar" style="width:' + 4 * c.QueueLen + 'px"></span>';
+		tr.appendChild(bar);
+		tr.appendChild(cell(c.Local + c.Remote));
+		tr.appendChild(cell(c.Local));
+		tr.appendChild(cell(c.Remote));
+		tr.appendChild(cell(c.StolenIn));
+		tr.appendChild(cell(c.StolenOut));
+		var seen = new Date(c.LastSeen);
+		tr.appendChild(cell(seen.getFullYear() > 1 ? seen.toLocaleTimeString() : "-"));
+		tbody.appendChild(tr);
+	});
+};
+</script>
+</body>
+</html>
+`
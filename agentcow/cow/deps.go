@@ -0,0 +1,202 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"context"
+	"fmt"
+	"net/rpc"
+	"time"
+)
+
+/*
+ * Dependencies between work items.
+ *
+ * A work item can list the IDs of the items it depends on (DependsOn).
+ * It is blocked, neither eaten nor handed out to thieves, until all of
+ * them have been eaten successfully by some cow of the herd.
+ *
+ * Every cow remembers the items it ate, and those it learnt were eaten
+ * elsewhere. Completions are not announced: a cow holding blocked items
+ * remembers the dependencies it awaits, and wander asks every cow it
+ * reaches which of them completed (CowRPC.GetCompleted), in one call per
+ * wander interval. Only IDs something depends on travel, and only to cows
+ * waiting for them. With partial views the eater may not be in the view
+ * of a waiting cow, but shuffling brings it there eventually.
+ *
+ * Both sets forget IDs after Options.HistoryTTL, unless they are still
+ * asked about: a blocked item keeps the completions it found, the eater
+ * those other cows ask for. An item still blocked after
+ * Options.DependencyTimeout, e.g. depending on an item eaten longer ago
+ * or given up on, or whose eater never came into view, is given up on
+ * too and goes to the dead-letter list. Completion also cancels a
+ * speculative copy still running, see speculate.go.
+ */
+
+const defHistoryTTL = time.Hour
+
+type ItemIDs struct {
+	IDs  []string
+	From string /* RPC address of the cow sending them */
+}
+
+/* Has every dependency of the item completed, remembers those awaited */
+func (c *Cow) ready(work WorkItem) bool {
+	if len(work.DependsOn) == 0 {
+		return true
+	}
+	c.completedMutex.Lock()
+	defer c.completedMutex.Unlock()
+	now := time.Now()
+	ready := true
+	for _, id := range work.DependsOn {
+		if _, ok := c.completed[id]; ok {
+			c.completed[id] = now
+		} else {
+			c.awaited[id] = now
+			ready = false
+		}
+	}
+	if _, ok := c.blocked[work.ID]; !ok && !ready {
+		c.blocked[work.ID] = now
+	} else if ready {
+		delete(c.blocked, work.ID)
+	}
+	return ready
+}
+
+/* Record items as completed, returns the IDs that were not known yet */
+func (c *Cow) markCompleted(ids ...string) []string {
+	c.completedMutex.Lock()
+	defer c.completedMutex.Unlock()
+	var added []string
+	for _, id := range ids {
+		if _, ok := c.completed[id]; id != "" && !ok {
+			c.completed[id] = time.Now()
+			delete(c.awaited, id)
+			added = append(added, id)
+		}
+	}
+	return added
+}
+
+/* Has the item with the given ID completed anywhere in the herd, as far as this cow knows */
+func (c *Cow) Completed(id string) bool {
+	c.completedMutex.Lock()
+	defer c.completedMutex.Unlock()
+	_, ok := c.completed[id]
+	return ok
+}
+
+/* Of the given IDs, those known to be completed, which are remembered longer */
+func (c *Cow) completedOf(ids []string) []string {
+	c.completedMutex.Lock()
+	defer c.completedMutex.Unlock()
+	var done []string
+	for _, id := range ids {
+		if _, ok := c.completed[id]; ok {
+			c.completed[id] = time.Now()
+			done = append(done, id)
+		}
+	}
+	return done
+}
+
+func (c *Cow) awaitedIDs() []string {
+	c.completedMutex.Lock()
+	defer c.completedMutex.Unlock()
+	ids := make([]string, 0, len(c.awaited))
+	for id := range c.awaited {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+/* Tell the cow running the other copy of an item that it was eaten here */
+func (c *Cow) notifyCompleted(ctx context.Context, cowaddr string, id string) {
+	defer c.wg.Done()
+	if ctx.Err() != nil {
+		return
+	}
+	client, err := c.dial(cowaddr)
+	if err != nil {
+		/* The other copy runs to its end and is then found completed here */
+		return
+	}
+	client.Call("CowRPC.Completed", &ItemIDs{IDs: []string{id}, From: c.opts.Addr}, new(ArgsNotUsed))
+	client.Close()
+}
+
+/* Ask another cow which of the dependencies this cow awaits completed */
+func (c *Cow) fetchCompleted(client *rpc.Client, cowaddr string) {
+	ids := c.awaitedIDs()
+	if len(ids) == 0 {
+		return
+	}
+	var reply ItemIDs
+	if err := client.Call("CowRPC.GetCompleted", &ItemIDs{IDs: ids, From: c.opts.Addr}, &reply); err != nil {
+		return
+	}
+	if added := c.markCompleted(reply.IDs...); len(added) != 0 {
+		c.Log(SubsysWander).Debug("fetched completed items", "peer", cowaddr, "new", len(added))
+	}
+}
+
+/*
+ * Forget IDs, and finished jobs, remembered longer than Options.HistoryTTL,
+ * and give up on items blocked longer than Options.DependencyTimeout.
+ */
+func (c *Cow) forget(ctx context.Context) {
+	defer c.wg.Done()
+	for sleep(ctx, min(c.opts.HistoryTTL, c.opts.DependencyTimeout)/10) {
+		cutoff := time.Now().Add(-c.opts.HistoryTTL)
+		c.completedMutex.Lock()
+		forgetBefore(c.completed, cutoff)
+		forgetBefore(c.awaited, cutoff)
+		c.completedMutex.Unlock()
+		c.forgetCancelled(cutoff)
+		c.forgetJobs(cutoff)
+		c.giveUpBlocked()
+	}
+}
+
+/* Move items blocked longer than Options.DependencyTimeout to the dead-letter list */
+func (c *Cow) giveUpBlocked() {
+	cutoff := time.Now().Add(-c.opts.DependencyTimeout)
+	expired := make(map[string]bool)
+	c.completedMutex.Lock()
+	for id, since := range c.blocked {
+		if since.Before(cutoff) {
+			expired[id] = true
+			delete(c.blocked, id)
+		}
+	}
+	c.completedMutex.Unlock()
+	if len(expired) == 0 {
+		return
+	}
+
+	/* Items no longer queued here were eaten, handed out or cancelled since */
+	for {
+		work, ok := c.queue.Pop(func(work WorkItem) bool { return expired[work.ID] })
+		if !ok {
+			return
+		}
+		var missing []string
+		for _, id := range work.DependsOn {
+			if !c.Completed(id) {
+				missing = append(missing, id)
+			}
+		}
+		err := fmt.Errorf("dependencies %v not completed within %v", missing, c.opts.DependencyTimeout)
+		c.Log(SubsysEat).Error("giving up on blocked work", "id", work.ID, "err", err)
+		c.deadLetter(work, err)
+	}
+}
+
+func forgetBefore(ids map[string]time.Time, cutoff time.Time) {
+	for id, t := range ids {
+		if t.Before(cutoff) {
+			delete(ids, id)
+		}
+	}
+}
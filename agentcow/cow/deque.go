@@ -0,0 +1,215 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ * Work-stealing deque, the default work queue.
+ *
+ * A Chase-Lev deque: items are pushed at the bottom, where the cow also
+ * eats them, newest first. Thieves take the oldest items from the top by
+ * moving it on with a compare-and-swap, without a lock, and meet the cow
+ * only over the last item. Items live in a ring buffer that grows when
+ * full, thieves still reading the old one find the same items there.
+ *
+ * Unlike the textbook deque the bottom has several owners: sowing,
+ * foraging, retries and RPCs all push items. Pushes and the cow's pops
+ * therefore take a mutex, uncontended while the cow eats alone; thieves
+ * never take it.
+ *
+ * Items are taken by predicate, so an item at either end may be one the
+ * taker cannot have (stolen itself, blocked, missing labels). Then the
+ * slow path scans the deque for one it can have and claims it in the
+ * middle. Every item carries a taken flag, set with a compare-and-swap by
+ * whoever takes it, so each item is taken once; items claimed in the
+ * middle are skipped and dropped when either end reaches them.
+ */
+
+const defDequeSize = 64
+
+type dequeEntry struct {
+	work  WorkItem
+	taken atomic.Bool
+}
+
+type dequeRing struct {
+	slots []atomic.Pointer[dequeEntry] /* length a power of two */
+}
+
+func newDequeRing(size int) *dequeRing {
+	return &dequeRing{slots: make([]atomic.Pointer[dequeEntry], size)}
+}
+
+func (r *dequeRing) get(i int64) *dequeEntry {
+	return r.slots[i&int64(len(r.slots)-1)].Load()
+}
+
+func (r *dequeRing) put(i int64, e *dequeEntry) {
+	r.slots[i&int64(len(r.slots)-1)].Store(e)
+}
+
+type dequeQueue struct {
+	mutex  sync.Mutex /* of the bottom end, see above */
+	top    atomic.Int64
+	bottom atomic.Int64
+	ring   atomic.Pointer[dequeRing]
+	n      atomic.Int64 /* items not taken */
+}
+
+func NewDequeQueue() Queue {
+	q := &dequeQueue{}
+	q.ring.Store(newDequeRing(defDequeSize))
+	return q
+}
+
+/* Take an entry, false if another thread took it first */
+func (q *dequeQueue) claim(e *dequeEntry) bool {
+	if !e.taken.CompareAndSwap(false, true) {
+		return false
+	}
+	q.n.Add(-1)
+	return true
+}
+
+/* Called with the mutex held */
+func (q *dequeQueue) pushBottom(e *dequeEntry) {
+	b, t := q.bottom.Load(), q.top.Load()
+	r := q.ring.Load()
+	if b-t >= int64(len(r.slots)) {
+		grown := newDequeRing(2 * len(r.slots))
+		for i := t; i < b; i++ {
+			grown.put(i, r.get(i))
+		}
+		q.ring.Store(grown)
+		r = grown
+	}
+	r.put(b, e)
+	q.bottom.Store(b + 1)
+}
+
+/* Take the bottom entry off the deque, called with the mutex held */
+func (q *dequeQueue) popBottom() *dequeEntry {
+	b := q.bottom.Load() - 1
+	r := q.ring.Load()
+	q.bottom.Store(b)
+	t := q.top.Load()
+	if t > b {
+		q.bottom.Store(b + 1)
+		return nil
+	}
+	e := r.get(b)
+	if t == b {
+		/* The last entry, a thief may be taking it */
+		if !q.top.CompareAndSwap(t, t+1) {
+			e = nil
+		}
+		q.bottom.Store(b + 1)
+	}
+	return e
+}
+
+/* Slow path: claim an entry anywhere in the deque, from the top or the bottom */
+func (q *dequeQueue) scan(want func(WorkItem) bool, fromTop bool) *dequeEntry {
+	t, b := q.top.Load(), q.bottom.Load()
+	r := q.ring.Load()
+	for k := int64(0); k < b-t; k++ {
+		i := b - 1 - k
+		if fromTop {
+			i = t + k
+		}
+		e := r.get(i)
+		if e == nil || e.taken.Load() || !want(e.work) {
+			continue
+		}
+		if q.claim(e) {
+			return e
+		}
+	}
+	return nil
+}
+
+func (q *dequeQueue) Push(work WorkItem) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.n.Add(1)
+	q.pushBottom(&dequeEntry{work: work})
+}
+
+func (q *dequeQueue) Pop(can func(WorkItem) bool) (WorkItem, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for {
+		e := q.popBottom()
+		if e == nil {
+			return WorkItem{}, false
+		}
+		if e.taken.Load() {
+			continue
+		}
+		if !can(e.work) {
+			q.pushBottom(e)
+			break
+		}
+		if q.claim(e) {
+			return e.work, true
+		}
+	}
+	if e := q.scan(can, false); e != nil {
+		return e.work, true
+	}
+	return WorkItem{}, false
+}
+
+func (q *dequeQueue) Steal(can func(WorkItem) bool) (WorkItem, bool) {
+	want := func(work WorkItem) bool { return work.Origin == OriginLocal && can(work) }
+	for {
+		t, b := q.top.Load(), q.bottom.Load()
+		if t >= b {
+			return WorkItem{}, false
+		}
+		e := q.ring.Load().get(t)
+		if e == nil {
+			/* The ring grew after another thief moved the top on */
+			continue
+		}
+		if e.taken.Load() {
+			q.top.CompareAndSwap(t, t+1)
+			continue
+		}
+		if !want(e.work) {
+			break
+		}
+		if !q.top.CompareAndSwap(t, t+1) {
+			continue
+		}
+		if q.claim(e) {
+			return e.work, true
+		}
+	}
+	if e := q.scan(want, true); e != nil {
+		return e.work, true
+	}
+	return WorkItem{}, false
+}
+
+func (q *dequeQueue) Stealable(can func(WorkItem) bool) int {
+	t, b := q.top.Load(), q.bottom.Load()
+	r := q.ring.Load()
+	n := 0
+	for i := t; i < b; i++ {
+		if e := r.get(i); e != nil && !e.taken.Load() && e.work.Origin == OriginLocal && can(e.work) {
+			n++
+		}
+	}
+	return n
+}
+
+func (q *dequeQueue) Len() int {
+	if n := q.n.Load(); n > 0 {
+		return int(n)
+	}
+	return 0
+}
@@ -0,0 +1,164 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func canEat(WorkItem) bool { return true }
+
+/* The cow eats the newest item, thieves take the oldest one sown locally */
+func TestDequeQueue(t *testing.T) {
+	q := NewDequeQueue()
+	for i := 0; i < 200; i++ {
+		origin := OriginLocal
+		if i%3 == 0 {
+			origin = OriginRemote
+		}
+		q.Push(WorkItem{ID: fmt.Sprint(i), Origin: origin})
+	}
+	if n := q.Stealable(canEat); n != 133 {
+		t.Errorf("%d items stealable, want 133", n)
+	}
+	if work, _ := q.Pop(canEat); work.ID != "199" {
+		t.Errorf("ate item %s first, want 199", work.ID)
+	}
+	/* Past the remote item 0 */
+	if work, _ := q.Steal(canEat); work.ID != "1" {
+		t.Errorf("stole item %s first, want 1", work.ID)
+	}
+	if work, _ := q.Steal(canEat); work.ID != "2" {
+		t.Errorf("stole item %s second, want 2", work.ID)
+	}
+	/* From the middle, and past it from either end */
+	if work, _ := q.Pop(func(w WorkItem) bool { return w.ID == "100" }); work.ID != "100" {
+		t.Errorf("ate item %s, want 100", work.ID)
+	}
+	if work, _ := q.Steal(func(w WorkItem) bool { return w.ID == "101" }); work.ID != "101" {
+		t.Errorf("stole item %s, want 101", work.ID)
+	}
+	if n := q.Len(); n != 195 {
+		t.Errorf("%d items left, want 195", n)
+	}
+
+	seen := map[string]bool{"199": true, "1": true, "2": true, "100": true, "101": true}
+	for q.Len() > 0 {
+		work, ok := q.Pop(canEat)
+		if !ok || seen[work.ID] {
+			t.Fatalf("popped %v %s", ok, work.ID)
+		}
+		seen[work.ID] = true
+	}
+	if len(seen) != 200 {
+		t.Errorf("%d items came out, want 200", len(seen))
+	}
+}
+
+/* Items pushed, eaten and stolen all at once come out once each */
+func TestDequeQueueConcurrent(t *testing.T) {
+	const pushers, thieves, items = 3, 3, 3000
+	q := NewDequeQueue()
+
+	var mutex sync.Mutex
+	seen := make(map[string]int)
+	take := func(work WorkItem, ok bool) {
+		if ok {
+			mutex.Lock()
+			seen[work.ID]++
+			mutex.Unlock()
+		}
+	}
+	done := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(seen) == items
+	}
+
+	var wg sync.WaitGroup
+	for p := 0; p < pushers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := p; i < items; i += pushers {
+				q.Push(WorkItem{ID: fmt.Sprint(i), Origin: OriginLocal})
+			}
+		}(p)
+	}
+	for i := 0; i < thieves; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !done() {
+				take(q.Steal(canEat))
+			}
+		}()
+	}
+	for !done() {
+		take(q.Pop(canEat))
+	}
+	wg.Wait()
+
+	for id, n := range seen {
+		if n != 1 {
+			t.Errorf("item %s came out %d times", id, n)
+		}
+	}
+	if q.Len() != 0 {
+		t.Errorf("%d items left", q.Len())
+	}
+}
+
+func benchmarkQueues(b *testing.B, bench func(*testing.B, Queue)) {
+	queues := map[string]func() Queue{"list": NewListQueue, "deque": NewDequeQueue}
+	for _, name := range []string{"list", "deque"} {
+		b.Run(name, func(b *testing.B) { bench(b, queues[name]()) })
+	}
+}
+
+/* The cow sowing and eating alone */
+func BenchmarkQueueEat(b *testing.B) {
+	benchmarkQueues(b, func(b *testing.B, q Queue) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			q.Push(WorkItem{Origin: OriginLocal})
+			q.Pop(canEat)
+		}
+	})
+}
+
+/* A burst of items sown and eaten */
+func BenchmarkQueueBurst(b *testing.B) {
+	benchmarkQueues(b, func(b *testing.B, q Queue) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < 1000; j++ {
+				q.Push(WorkItem{Origin: OriginLocal})
+			}
+			for q.Len() > 0 {
+				q.Pop(canEat)
+			}
+		}
+	})
+}
+
+/* Thieves stealing while the cow sows and eats, there is always something to steal */
+func BenchmarkQueueSteal(b *testing.B) {
+	benchmarkQueues(b, func(b *testing.B, q Queue) {
+		for i := 0; i < 100; i++ {
+			q.Push(WorkItem{Origin: OriginLocal})
+		}
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				q.Push(WorkItem{Origin: OriginLocal})
+				if i%2 == 0 {
+					q.Pop(canEat)
+				} else {
+					q.Steal(canEat)
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,247 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+/*
+ * Discovery lets a cow announce itself to the herd and learn about other cows.
+ */
+type Discovery interface {
+	/* Send this cow's beacon to the herd */
+	Announce(b Beacon) error
+
+	/*
+	 * Wait for the next beacon and return it along with the RPC address of
+	 * the cow that sent it. Beacons that cannot be decoded or authenticated
+	 * are returned as a *BeaconError.
+	 */
+	Receive() (Beacon, string, error)
+
+	/* Stop discovery, unblocking Receive */
+	Close() error
+}
+
+type BeaconError struct {
+	From string
+	Err  error
+}
+
+func (e *BeaconError) Error() string {
+	return fmt.Sprintf("beacon from %s: %s", e.From, e.Err)
+}
+
+/*
+ * UDPDiscovery sends beacons over UDP, for IPv4 and IPv6.
+ *
+ * IPv4: cows discover each other with broadcasts on the subnet of the interface.
+ * IPv6: there is no broadcast, cows send beacons to a link-local multicast
+ *       group on the interface instead.
+ *
+ * RPC listens on all addresses of both stacks, so a cow can be reached over
+ * whichever address it advertised in its beacon.
+ */
+type UDPDiscovery struct {
+	iface  string
+	ipaddr *net.IPNet
+	port   string
+	secret []byte
+	conn   *net.UDPConn
+}
+
+/* Link-local multicast group used for discovery over IPv6 (ff02::114, "any private experiment") */
+const multicast6 = "ff02::114"
+
+/*
+ * Listen for beacons sent to port (e.g. DefaultPort) on the interface with
+ * the given address, see InterfaceAddr. Beacons are authenticated with the
+ * secret if it is not empty.
+ */
+func NewUDPDiscovery(iface string, ipaddr *net.IPNet, port string, secret []byte) (*UDPDiscovery, error) {
+	d := &UDPDiscovery{iface, ipaddr, port, secret, nil}
+
+	var err error
+	if isIPv6(ipaddr) {
+		var myiface *net.Interface
+		var addr *net.UDPAddr
+		myiface, err = net.InterfaceByName(iface)
+		if err != nil {
+			return nil, err
+		}
+		addr, err = net.ResolveUDPAddr("udp6", "["+d.Target()+"]"+port)
+		if err != nil {
+			return nil, err
+		}
+		d.conn, err = net.ListenMulticastUDP("udp6", myiface, addr)
+	} else {
+		var addr *net.UDPAddr
+		addr, err = net.ResolveUDPAddr("udp4", "0.0.0.0"+port)
+		if err != nil {
+			return nil, err
+		}
+		d.conn, err = net.ListenUDP("udp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+/* Address beacons are sent to */
+func (d *UDPDiscovery) Target() string {
+	return discoveryAddress(d.ipaddr)
+}
+
+func (d *UDPDiscovery) Announce(b Beacon) error {
+	var addr *net.UDPAddr
+	var err error
+	if isIPv6(d.ipaddr) {
+		addr, err = net.ResolveUDPAddr("udp6", "["+d.Target()+"%"+d.iface+"]"+d.port)
+	} else {
+		addr, err = net.ResolveUDPAddr("udp4", d.Target()+d.port)
+	}
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialUDP(addr.Network(), nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(makeBeacon(b, d.secret))
+	return err
+}
+
+func (d *UDPDiscovery) Receive() (Beacon, string, error) {
+	var buf [maxBeaconSize]byte
+	n, cowaddr, err := d.conn.ReadFromUDP(buf[0:])
+	if err != nil {
+		return Beacon{}, "", err
+	}
+
+	beacon, err := parseBeacon(buf[:n], d.secret)
+	if err != nil {
+		return beacon, "", &BeaconError{cowaddr.IP.String(), err}
+	}
+	return beacon, beaconRPCAddr(beacon.Addr, cowaddr, d.port), nil
+}
+
+func (d *UDPDiscovery) Close() error {
+	return d.conn.Close()
+}
+
+/*
+ * Address of the cow on the given interface, see pickAddress.
+ */
+func InterfaceAddr(iface string, ipv6 bool) (*net.IPNet, error) {
+	myiface, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := myiface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	ipaddr := pickAddress(addresses, ipv6)
+	if ipaddr == nil {
+		return nil, fmt.Errorf("no address on interface %s", iface)
+	}
+	return ipaddr, nil
+}
+
+/*
+ * Pick the address of the cow among the addresses of the interface.
+ * IPv4 is preferred unless ipv6 is set. Among IPv6 addresses global ones
+ * are preferred over link-local ones. If there is no address of the
+ * preferred family, the other family is used.
+ */
+func pickAddress(addresses []net.Addr, ipv6 bool) *net.IPNet {
+	var ip4, ip6, ll6 *net.IPNet
+
+	for _, addr := range addresses {
+		ipaddr, ok := addr.(*net.IPNet)
+		if !ok || ipaddr.IP.IsLoopback() {
+			continue
+		}
+		switch {
+		case ipaddr.IP.To4() != nil:
+			ip4 = ipaddr
+		case ipaddr.IP.IsLinkLocalUnicast():
+			ll6 = ipaddr
+		case ipaddr.IP.IsGlobalUnicast():
+			ip6 = ipaddr
+		}
+	}
+	if ip6 == nil {
+		ip6 = ll6
+	}
+
+	if (ipv6 && ip6 != nil) || ip4 == nil {
+		return ip6
+	}
+	return ip4
+}
+
+func isIPv6(ipaddr *net.IPNet) bool {
+	return ipaddr.IP.To4() == nil
+}
+
+/*
+ * Address beacons are sent to: the broadcast address of the subnet for
+ * IPv4, the discovery multicast group for IPv6.
+ */
+func discoveryAddress(ipaddr *net.IPNet) string {
+	if isIPv6(ipaddr) {
+		return multicast6
+	}
+
+	ip := ipaddr.IP.To4()
+	mask := ipaddr.Mask
+	if len(mask) == net.IPv6len {
+		mask = mask[12:]
+	}
+	bcast := make(net.IP, len(ip))
+	for i := range bcast {
+		bcast[i] = ip[i] | ^mask[i]
+	}
+	return bcast.String()
+}
+
+/* RPC address of a cow with the given IP, host:port with [] around IPv6 */
+func RPCAddr(ip string, port string) string {
+	return net.JoinHostPort(ip, strings.TrimPrefix(port, ":"))
+}
+
+/*
+ * RPC address of the cow that sent a beacon from the given address.
+ * The advertised address is used unless it is unspecified or link-local:
+ * the zone of a link-local address is only meaningful on the sender, so
+ * the source address of the beacon (zoned for this cow) is used instead,
+ * with the advertised port or the given one.
+ */
+func beaconRPCAddr(advertised string, from *net.UDPAddr, port string) string {
+	host, p, err := net.SplitHostPort(advertised)
+	if err == nil {
+		ip := net.ParseIP(stripZone(host))
+		if ip != nil && !ip.IsUnspecified() && !ip.IsLinkLocalUnicast() {
+			return advertised
+		}
+		port = p
+	}
+	fromip := from.IP.String()
+	if from.Zone != "" {
+		fromip += "%" + from.Zone
+	}
+	return RPCAddr(fromip, port)
+}
+
+func stripZone(host string) string {
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		return host[:i]
+	}
+	return host
+}
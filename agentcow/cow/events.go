@@ -0,0 +1,58 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"encoding/json"
+	"time"
+)
+
+/*
+ * Event log.
+ *
+ * With Options.EventLog set, a cow appends every queue event to it as one
+ * JSON object per line (JSONL), for post-hoc analysis of how the herd
+ * balanced its load. The logs of all cows can be merged into a herd-wide
+ * timeline with cmd/herdlog.
+ */
+
+const (
+	EventEnqueue    = "enqueue"     /* item sown locally */
+	EventDequeue    = "dequeue"     /* item taken off the queue to be eaten */
+	EventRelease    = "release"     /* held item became due and was queued */
+	EventStealOut   = "steal-out"   /* item handed out to Peer */
+	EventStealIn    = "steal-in"    /* item foraged from Peer */
+	EventForward    = "forward"     /* steal request forwarded to Peer got the item */
+	EventShedOut    = "shed-out"    /* item offered to Peer and accepted */
+	EventShedIn     = "shed-in"     /* item offered by Peer was accepted */
+	EventStart      = "start"       /* started eating item */
+	EventFinish     = "finish"      /* finished eating item */
+	EventPeerJoined = "peer-joined" /* Peer joined the herd */
+	EventPeerLost   = "peer-lost"   /* Peer can no longer be reached */
+	EventRetry      = "retry"       /* failed item queued again, or handed to Peer */
+	EventDeadLetter = "dead-letter" /* item failed too often and was given up on */
+	EventSpeculate  = "speculate"   /* started a copy of a straggler of Peer */
+	EventOvertaken  = "overtaken"   /* Peer ate the item first, eating it here was cancelled */
+	EventCancel     = "cancel"      /* item withdrawn with Cancel */
+)
+
+type Event struct {
+	Time     time.Time
+	Cow      string
+	Type     string
+	Item     string `json:",omitempty"` /* ID of the work item */
+	Peer     string `json:",omitempty"` /* address of the other cow */
+	QueueLen int    /* length of the queue after the event */
+}
+
+/* Append an event to the event log, if there is one */
+func (c *Cow) event(typ string, item string, peer string) {
+	if c.opts.EventLog == nil {
+		return
+	}
+	e := Event{time.Now(), c.opts.ID, typ, item, peer, c.queue.Len()}
+	line, _ := json.Marshal(e)
+
+	c.eventMutex.Lock()
+	defer c.eventMutex.Unlock()
+	c.opts.EventLog.Write(append(line, '\n'))
+}
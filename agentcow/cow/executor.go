@@ -0,0 +1,29 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"context"
+	"time"
+)
+
+/*
+ * Executor eats a work item. Execute should return early with ctx.Err()
+ * when ctx is cancelled.
+ */
+type Executor interface {
+	Execute(ctx context.Context, work WorkItem) error
+}
+
+type ExecutorFunc func(ctx context.Context, work WorkItem) error
+
+func (f ExecutorFunc) Execute(ctx context.Context, work WorkItem) error {
+	return f(ctx, work)
+}
+
+/* SleepExecutor holds on to a work item for Duration seconds */
+var SleepExecutor = ExecutorFunc(func(ctx context.Context, work WorkItem) error {
+	if !sleep(ctx, time.Second*time.Duration(work.Duration)) {
+		return ctx.Err()
+	}
+	return nil
+})
@@ -0,0 +1,154 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+ * Weighted fair queuing between tenants.
+ *
+ * fairQueue keeps a FIFO per tenant (WorkItem.Tenant) and serves the
+ * tenant that has had the least service for its weight: every item taken
+ * off the queue, eaten here or stolen, advances the virtual time of its
+ * tenant by its Duration (at least 1) divided by the tenant's weight. A
+ * tenant that was idle starts at the virtual time of the queue, so it
+ * cannot bank service while it has nothing queued. A noisy tenant thus
+ * cannot starve the others, and thieves get items of under-served tenants
+ * first.
+ */
+
+const defTenantWeight = 1
+
+type tenantQueue struct {
+	items list.List
+	vtime float64 /* service received, divided by weight */
+}
+
+type fairQueue struct {
+	mutex   sync.Mutex
+	weights map[string]int
+	tenants map[string]*tenantQueue
+	vtime   float64 /* virtual time of the last item taken */
+	n       int
+}
+
+/* Fair queue with the given tenant weights, other tenants weigh 1 */
+func NewFairQueue(weights map[string]int) Queue {
+	return &fairQueue{weights: weights, tenants: make(map[string]*tenantQueue)}
+}
+
+func (q *fairQueue) weight(tenant string) float64 {
+	if w, ok := q.weights[tenant]; ok && w > 0 {
+		return float64(w)
+	}
+	return defTenantWeight
+}
+
+func (q *fairQueue) Push(work WorkItem) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	t, ok := q.tenants[work.Tenant]
+	if !ok {
+		t = &tenantQueue{}
+		q.tenants[work.Tenant] = t
+	}
+	if t.items.Len() == 0 && t.vtime < q.vtime {
+		t.vtime = q.vtime
+	}
+	t.items.PushBack(work)
+	q.n++
+}
+
+/* Tenants with queued items, least served first */
+func (q *fairQueue) byService() []string {
+	var names []string
+	for name, t := range q.tenants {
+		if t.items.Len() > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ti, tj := q.tenants[names[i]], q.tenants[names[j]]
+		if ti.vtime != tj.vtime {
+			return ti.vtime < tj.vtime
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+/* Take the first item of the least served tenant that pick accepts */
+func (q *fairQueue) take(pick func(WorkItem) bool) (WorkItem, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, name := range q.byService() {
+		t := q.tenants[name]
+		for e := t.items.Front(); e != nil; e = e.Next() {
+			work := e.Value.(WorkItem)
+			if !pick(work) {
+				continue
+			}
+			t.items.Remove(e)
+			q.n--
+			cost := work.Duration
+			if cost < 1 {
+				cost = 1
+			}
+			q.vtime = t.vtime
+			t.vtime += float64(cost) / q.weight(name)
+			return work, true
+		}
+	}
+	return WorkItem{}, false
+}
+
+func (q *fairQueue) Pop(can func(WorkItem) bool) (WorkItem, bool) {
+	return q.take(can)
+}
+
+func (q *fairQueue) Steal(can func(WorkItem) bool) (WorkItem, bool) {
+	return q.take(func(work WorkItem) bool {
+		return work.Origin == OriginLocal && can(work)
+	})
+}
+
+func (q *fairQueue) Stealable(can func(WorkItem) bool) int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	n := 0
+	for _, t := range q.tenants {
+		for e := t.items.Front(); e != nil; e = e.Next() {
+			work := e.Value.(WorkItem)
+			if work.Origin == OriginLocal && can(work) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func (q *fairQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.n
+}
+
+/* Parse tenant weights given as tenant=weight,... */
+func ParseTenantWeights(s string) (map[string]int, error) {
+	weights := make(map[string]int)
+	for _, tw := range ParseLabels(s) {
+		tenant, weight, ok := strings.Cut(tw, "=")
+		w, err := strconv.Atoi(strings.TrimSpace(weight))
+		if !ok || err != nil || w < 1 {
+			return nil, fmt.Errorf("bad tenant weight %q, want tenant=weight with weight at least 1", tw)
+		}
+		weights[strings.TrimSpace(tenant)] = w
+	}
+	return weights, nil
+}
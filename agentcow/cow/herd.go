@@ -0,0 +1,152 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+ * Herd table.
+ *
+ * The cows this cow knows, in the order they joined, with the number of
+ * items each has that this cow can steal. Discover adds cows, wander
+ * updates their entries and forage reads them, all concurrently. Evict
+ * and shuffles remove cows, which stops their wander thread, so that a
+ * cow removed and added again has only the new one.
+ *
+ * Every update carries the time the value was measured and a sequence
+ * number, increasing for each cow. An update older than the entry is
+ * dropped. Forage ignores entries whose last few wander rounds failed,
+ * e.g. of cows that can no longer be reached. Rounds are counted rather
+ * than timed, so that a cow slowed down by load, slow dials or latency
+ * does not find every entry stale.
+ */
+
+type herdEntry struct {
+	Addr      string
+	Stealable int           /* items the cow has that this cow can steal */
+	QueueLen  int           /* all items the cow has */
+	AvgEat    time.Duration /* time the cow takes to eat an item, on average */
+	RTT       time.Duration /* to connect and call the cow, moving average */
+	Measured  time.Time     /* when the values were fetched */
+	Seq       uint64        /* of the last update */
+	Tried     uint64        /* sequence number of the last wander round started */
+
+	stop context.CancelFunc /* of the cow's wander thread */
+}
+
+type herdTable struct {
+	mutex   sync.RWMutex
+	addrs   []string
+	entries map[string]*herdEntry
+	stale   bool /* every entry was stale last time */
+}
+
+func newHerdTable() *herdTable {
+	return &herdTable{entries: make(map[string]*herdEntry)}
+}
+
+/* Add a cow whose wander thread stop stops, false if it is already known */
+func (h *herdTable) add(cowaddr string, stop context.CancelFunc) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.entries[cowaddr]; ok {
+		return false
+	}
+	h.addrs = append(h.addrs, cowaddr)
+	h.entries[cowaddr] = &herdEntry{Addr: cowaddr, stop: stop}
+	return true
+}
+
+/* Addresses of all known cows, in the order they joined */
+func (h *herdTable) cows() []string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return append([]string(nil), h.addrs...)
+}
+
+func (h *herdTable) len() int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return len(h.addrs)
+}
+
+/* Start a wander round of the cow, returns the sequence number of its update */
+func (h *herdTable) nextSeq(cowaddr string) uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if e, ok := h.entries[cowaddr]; ok {
+		e.Tried++
+		return e.Tried
+	}
+	return 0
+}
+
+/* Record values measured at the given time, unless newer ones are known */
+func (h *herdTable) update(cowaddr string, values herdEntry, measured time.Time, seq uint64) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	/* Also drops updates of a wander thread stopped since, see add */
+	e, ok := h.entries[cowaddr]
+	if !ok || seq <= e.Seq || seq > e.Tried {
+		return false
+	}
+	e.Stealable, e.QueueLen, e.AvgEat = values.Stealable, values.QueueLen, values.AvgEat
+	e.RTT = ewma(e.RTT, values.RTT)
+	e.Measured, e.Seq = measured, seq
+	return true
+}
+
+/*
+ * Entries updated in one of the last missed wander rounds, in the order
+ * the cows joined. changed is true when every entry has just become
+ * stale, or when some entry is no longer stale since.
+ */
+func (h *herdTable) fresh(missed uint64) (entries []herdEntry, changed bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	stale := len(h.addrs) != 0
+	for _, cowaddr := range h.addrs {
+		e := h.entries[cowaddr]
+		if e.Tried-e.Seq > missed {
+			continue
+		}
+		/* Not stale, but a cow that just joined has no values yet */
+		stale = false
+		if e.Seq != 0 {
+			entries = append(entries, *e)
+		}
+	}
+	changed, h.stale = stale != h.stale, stale
+	return entries, changed
+}
+
+/* Remove a cow and stop its wander thread, false if it was not known */
+func (h *herdTable) remove(cowaddr string) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	e, ok := h.entries[cowaddr]
+	if !ok {
+		return false
+	}
+	if e.stop != nil {
+		e.stop()
+	}
+	delete(h.entries, cowaddr)
+	for i, addr := range h.addrs {
+		if addr == cowaddr {
+			h.addrs = append(h.addrs[:i], h.addrs[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func (h *herdTable) known(cowaddr string) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	_, ok := h.entries[cowaddr]
+	return ok
+}
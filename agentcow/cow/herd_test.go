@@ -0,0 +1,75 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+/* Entries go stale after missed wander rounds, however long those took */
+func TestHerdFresh(t *testing.T) {
+	h := newHerdTable()
+	h.add("a", nil)
+	h.add("b", nil)
+	/* Cows that just joined are neither fresh nor stale */
+	if cows, changed := h.fresh(staleWanders); len(cows) != 0 || changed {
+		t.Errorf("%d fresh cows changed %v before any update, want 0 false", len(cows), changed)
+	}
+
+	long := time.Now().Add(-time.Hour)
+	h.update("a", herdEntry{Stealable: 1}, long, h.nextSeq("a"))
+	h.update("b", herdEntry{Stealable: 2}, long, h.nextSeq("b"))
+	if cows, _ := h.fresh(staleWanders); len(cows) != 2 {
+		t.Errorf("%d fresh cows, want 2", len(cows))
+	}
+
+	/* An update from a round started before the last one is dropped */
+	old := h.nextSeq("a")
+	h.update("a", herdEntry{Stealable: 3}, time.Now(), h.nextSeq("a"))
+	if h.update("a", herdEntry{Stealable: 4}, time.Now(), old) {
+		t.Error("older update applied")
+	}
+
+	for i := 0; i < staleWanders+1; i++ {
+		h.nextSeq("b")
+	}
+	cows, changed := h.fresh(staleWanders)
+	if len(cows) != 1 || cows[0].Addr != "a" || cows[0].Stealable != 3 || changed {
+		t.Errorf("fresh cows %v changed %v, want a with 3 items", cows, changed)
+	}
+
+	for i := 0; i < staleWanders+1; i++ {
+		h.nextSeq("a")
+	}
+	if cows, changed := h.fresh(staleWanders); len(cows) != 0 || !changed {
+		t.Errorf("%d fresh cows changed %v, want 0 true", len(cows), changed)
+	}
+	h.update("b", herdEntry{Stealable: 2}, time.Now(), h.nextSeq("b"))
+	if cows, changed := h.fresh(staleWanders); len(cows) != 1 || !changed {
+		t.Errorf("%d fresh cows changed %v, want 1 true", len(cows), changed)
+	}
+}
+
+/* Removing a cow stops its wander thread, whose late updates do not reach a new entry */
+func TestHerdRemove(t *testing.T) {
+	h := newHerdTable()
+	ctx, stop := context.WithCancel(context.Background())
+	h.add("a", stop)
+	for i := 0; i < 5; i++ {
+		h.nextSeq("a")
+	}
+	seq := h.nextSeq("a")
+
+	if !h.remove("a") || ctx.Err() == nil {
+		t.Fatal("wander thread of a removed cow not stopped")
+	}
+	h.add("a", nil)
+	if h.update("a", herdEntry{Stealable: 1}, time.Now(), seq) {
+		t.Error("update of the stopped wander thread applied")
+	}
+	h.update("a", herdEntry{Stealable: 2}, time.Now(), h.nextSeq("a"))
+	if cows, _ := h.fresh(staleWanders); len(cows) != 1 || cows[0].Stealable != 2 {
+		t.Errorf("fresh cows %v, want a with 2 items", cows)
+	}
+}
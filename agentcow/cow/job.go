@@ -0,0 +1,297 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+ * Jobs, batches of work items submitted together.
+ *
+ * SubmitJob tags every item with the job ID and the address of this cow,
+ * which tracks the job. Whichever cow has an item reports its progress to
+ * the tracking cow (CowRPC.JobProgress): running when it is eaten, queued
+ * again when it is retried, done, failed when it is given up on, cancelled.
+ * Reports carry the attempt, so one that arrives late does not undo a
+ * later one, and are sent again until the tracking cow takes them.
+ *
+ * Once every item is done, failed or cancelled the job is finished:
+ * Options.OnJobDone is called and WaitJob returns. Progress is served as
+ * CowAdmin.Job, CowAdmin.Jobs and on /jobs of the dashboard. Finished
+ * jobs are forgotten after Options.HistoryTTL.
+ */
+
+const maxReportBackoff = time.Minute
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+type JobStatus struct {
+	ID        string
+	Items     int
+	Queued    int
+	Running   int
+	Done      int
+	Failed    int /* given up on, see retry.go */
+	Cancelled int
+	Submitted time.Time
+	Finished  time.Time /* zero while items are left */
+}
+
+type JobProgressArgs struct {
+	Job     string
+	ID      string /* of the item */
+	State   string
+	Attempt int
+	From    string /* RPC address of the reporting cow */
+}
+
+type jobItem struct {
+	state   string
+	attempt int
+}
+
+type job struct {
+	status JobStatus
+	items  map[string]jobItem
+	done   chan struct{} /* closed when the job finishes */
+}
+
+func jobFinal(state string) bool {
+	return state == JobDone || state == JobFailed || state == JobCancelled
+}
+
+/* Order of the states within an attempt */
+func jobRank(state string) int {
+	switch state {
+	case JobQueued:
+		return 0
+	case JobRunning:
+		return 1
+	}
+	return 2
+}
+
+func (s *JobStatus) count(state string, n int) {
+	switch state {
+	case JobQueued:
+		s.Queued += n
+	case JobRunning:
+		s.Running += n
+	case JobDone:
+		s.Done += n
+	case JobFailed:
+		s.Failed += n
+	case JobCancelled:
+		s.Cancelled += n
+	}
+}
+
+/*
+ * Submit the items of a job, this cow tracks it. Items without an ID are
+ * given one.
+ */
+func (c *Cow) SubmitJob(id string, items []WorkItem) error {
+	if id == "" || len(items) == 0 {
+		return errors.New("a job needs an ID and items")
+	}
+	j := &job{
+		status: JobStatus{ID: id, Items: len(items), Queued: len(items), Submitted: time.Now()},
+		items:  make(map[string]jobItem),
+		done:   make(chan struct{}),
+	}
+	for i := range items {
+		if items[i].ID == "" {
+			items[i].ID = fmt.Sprintf("%s/%d", id, i)
+		}
+		if _, ok := j.items[items[i].ID]; ok {
+			return fmt.Errorf("item %s is in job %s twice", items[i].ID, id)
+		}
+		items[i].Job, items[i].JobCow = id, c.opts.Addr
+		j.items[items[i].ID] = jobItem{state: JobQueued}
+	}
+
+	c.jobMutex.Lock()
+	if _, ok := c.jobs[id]; ok {
+		c.jobMutex.Unlock()
+		return fmt.Errorf("job %s exists", id)
+	}
+	c.jobs[id] = j
+	c.jobMutex.Unlock()
+
+	c.Log(SubsysMoo).Info("submitted job", "job", id, "items", len(items))
+	for _, work := range items {
+		c.Submit(work)
+	}
+	return nil
+}
+
+func (c *Cow) Job(id string) (JobStatus, bool) {
+	c.jobMutex.Lock()
+	defer c.jobMutex.Unlock()
+	j, ok := c.jobs[id]
+	if !ok {
+		return JobStatus{}, false
+	}
+	return j.status, true
+}
+
+/* Jobs tracked by this cow, oldest first */
+func (c *Cow) Jobs() []JobStatus {
+	c.jobMutex.Lock()
+	jobs := make([]JobStatus, 0, len(c.jobs))
+	for _, j := range c.jobs {
+		jobs = append(jobs, j.status)
+	}
+	c.jobMutex.Unlock()
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Submitted.Before(jobs[k].Submitted) })
+	return jobs
+}
+
+/* Wait until a job tracked by this cow finishes */
+func (c *Cow) WaitJob(ctx context.Context, id string) (JobStatus, error) {
+	c.jobMutex.Lock()
+	j, ok := c.jobs[id]
+	c.jobMutex.Unlock()
+	if !ok {
+		return JobStatus{}, fmt.Errorf("unknown job %s", id)
+	}
+	select {
+	case <-ctx.Done():
+		status, _ := c.Job(id)
+		return status, ctx.Err()
+	case <-j.done:
+	}
+	status, _ := c.Job(id)
+	return status, nil
+}
+
+/* Record the progress of an item of a job tracked by this cow */
+func (c *Cow) jobProgress(args *JobProgressArgs) {
+	c.jobMutex.Lock()
+	j, ok := c.jobs[args.Job]
+	if !ok || !j.status.Finished.IsZero() {
+		c.jobMutex.Unlock()
+		return
+	}
+	cur, ok := j.items[args.ID]
+	if !ok || jobFinal(cur.state) || args.Attempt < cur.attempt ||
+		(args.Attempt == cur.attempt && jobRank(args.State) <= jobRank(cur.state)) {
+		c.jobMutex.Unlock()
+		return
+	}
+	j.items[args.ID] = jobItem{state: args.State, attempt: args.Attempt}
+	j.status.count(cur.state, -1)
+	j.status.count(args.State, 1)
+
+	s := &j.status
+	finished := s.Done+s.Failed+s.Cancelled == s.Items
+	if finished {
+		s.Finished = time.Now()
+		close(j.done)
+	}
+	status := j.status
+	c.jobMutex.Unlock()
+
+	if finished {
+		c.Log(SubsysMoo).Info("finished job", "job", status.ID, "done", status.Done, "failed", status.Failed, "cancelled", status.Cancelled)
+		if c.opts.OnJobDone != nil {
+			c.opts.OnJobDone(status)
+		}
+	}
+}
+
+/* Report the progress of an item to the cow tracking its job */
+func (c *Cow) reportJob(work WorkItem, state string) {
+	if work.Job == "" {
+		return
+	}
+	args := &JobProgressArgs{Job: work.Job, ID: work.ID, State: state, Attempt: work.Attempts, From: c.opts.Addr}
+	if work.JobCow == c.opts.Addr {
+		c.jobProgress(args)
+		return
+	}
+
+	/* Reports are sent from RPCs too, see addCow */
+	c.stopMutex.RLock()
+	defer c.stopMutex.RUnlock()
+	if c.ctx == nil || c.ctx.Err() != nil {
+		return
+	}
+	c.wg.Add(1)
+	go c.sendJobReport(c.ctx, work.JobCow, args)
+}
+
+/*
+ * Send a report until the tracking cow takes it: a lost final report
+ * would leave the job unfinished. Gives up after Options.HistoryTTL.
+ */
+func (c *Cow) sendJobReport(ctx context.Context, jobcow string, args *JobProgressArgs) {
+	defer c.wg.Done()
+	log := c.Log(SubsysMoo)
+	giveUp := time.Now().Add(c.opts.HistoryTTL)
+	for backoff := c.opts.WanderInterval; ; backoff = min(2*backoff, maxReportBackoff) {
+		client, err := c.dial(jobcow)
+		if err == nil {
+			err = client.Call("CowRPC.JobProgress", args, new(ArgsNotUsed))
+			client.Close()
+		}
+		if err == nil {
+			return
+		}
+		if time.Now().After(giveUp) {
+			log.Warn("gave up reporting job progress", "job", args.Job, "id", args.ID, "state", args.State, "peer", jobcow, "err", err)
+			return
+		}
+		log.Debug("cannot report job progress, retrying", "job", args.Job, "id", args.ID, "peer", jobcow, "err", err, "after", backoff)
+		if !sleep(ctx, backoff) {
+			return
+		}
+	}
+}
+
+/* Forget jobs that finished before the cutoff */
+func (c *Cow) forgetJobs(cutoff time.Time) {
+	c.jobMutex.Lock()
+	defer c.jobMutex.Unlock()
+	for id, j := range c.jobs {
+		if !j.status.Finished.IsZero() && j.status.Finished.Before(cutoff) {
+			delete(c.jobs, id)
+		}
+	}
+}
+
+/* /jobs lists the jobs tracked by this cow, /jobs/<id> one of them */
+func (c *Cow) serveJobs(w http.ResponseWriter, r *http.Request) {
+	var reply interface{} = c.Jobs()
+	if id := strings.TrimPrefix(r.URL.Path, "/jobs/"); id != r.URL.Path && id != "" {
+		status, ok := c.Job(id)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		reply = status
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+func (t *CowRPC) JobProgress(args *JobProgressArgs, _ *ArgsNotUsed) error {
+	if t.c.partitioned(args.From) {
+		return errChaosPartition
+	}
+	t.c.jobProgress(args)
+	return nil
+}
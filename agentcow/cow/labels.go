@@ -0,0 +1,43 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"strings"
+)
+
+/*
+ * Capability labels.
+ *
+ * A cow has labels (Options.Labels), e.g. the tools or data sets
+ * available on its machine. A work item lists the labels it requires and
+ * can only be eaten by a cow that has all of them.
+ */
+
+/* Parse a comma separated list of labels */
+func ParseLabels(s string) []string {
+	var labels []string
+	for _, l := range strings.Split(s, ",") {
+		l = strings.TrimSpace(l)
+		if l != "" {
+			labels = append(labels, l)
+		}
+	}
+	return labels
+}
+
+/* Can a cow with labels have eat the work item */
+func canRun(have []string, work WorkItem) bool {
+	for _, need := range work.Requires {
+		found := false
+		for _, l := range have {
+			if l == need {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	for s, want := range map[string][]string{
+		"":             nil,
+		"gpu":          {"gpu"},
+		" gpu, ,ssd, ": {"gpu", "ssd"},
+	} {
+		if got := ParseLabels(s); !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseLabels(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+/* A thief without every label an item requires is never given it */
+func TestStealLabels(t *testing.T) {
+	c := New(Options{Logger: DiscardLogger()})
+	rpc := &CowRPC{c}
+	c.Submit(WorkItem{ID: "gpu", Requires: []string{"gpu"}})
+	c.Submit(WorkItem{ID: "both", Requires: []string{"gpu", "ssd"}})
+	c.Submit(WorkItem{ID: "any"})
+
+	for _, tc := range []struct {
+		labels []string
+		want   []string
+	}{
+		{nil, []string{"any"}},
+		{[]string{"ssd"}, []string{"any"}},
+		{[]string{"gpu"}, []string{"any", "gpu"}},
+		{[]string{"ssd", "gpu"}, []string{"any", "both", "gpu"}},
+	} {
+		args := &StealArgs{Labels: tc.labels, Thief: "thief"}
+		var n int
+		if err := rpc.GetStealableLen(args, &n); err != nil || n != len(tc.want) {
+			t.Errorf("thief with %v can steal %d items (%v), want %d", tc.labels, n, err, len(tc.want))
+		}
+		var stolen []string
+		for {
+			var work WorkItem
+			if err := rpc.GetWorkItem(args, &work); err != nil {
+				t.Fatal(err)
+			}
+			if work.empty() {
+				break
+			}
+			stolen = append(stolen, work.ID)
+		}
+		sort.Strings(stolen)
+		if !reflect.DeepEqual(stolen, tc.want) {
+			t.Errorf("thief with %v stole %v, want %v", tc.labels, stolen, tc.want)
+		}
+		/* Put them back for the next thief */
+		for _, id := range stolen {
+			c.Submit(WorkItem{ID: id, Requires: map[string][]string{"gpu": {"gpu"}, "both": {"gpu", "ssd"}}[id]})
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"time"
+)
+
+/*
+ * Latency-aware stealing.
+ *
+ * Wander measures the round trip time to every cow (connecting and one
+ * call, as a steal takes) and fetches how long the cow takes to eat an
+ * item on average. An item queued at a cow waits about
+ *
+ *     wait = QueueLen * AvgEat
+ *
+ * before it is eaten there. Stealing it is only worth it when that exceeds
+ * the RTT plus the time to eat the item, the latency policy steals from
+ * the cow where the gain, wait - RTT - AvgEat, is the largest. A cow that
+ * has not eaten anything yet is assumed to eat as fast as this one; if
+ * neither has, the cow with the most items is picked, like the max policy.
+ * When no cow is worth it the policy declines: the cow waits, rather than
+ * asking cows further away as it does when nothing can be stolen (see
+ * forageFar), and cows forwarding steal requests do not pass them on.
+ */
+
+/* Weight of a new sample in moving averages */
+const ewmaWeight = 0.25
+
+func ewma(avg time.Duration, sample time.Duration) time.Duration {
+	if avg == 0 {
+		return sample
+	}
+	return avg + time.Duration(ewmaWeight*float64(sample-avg))
+}
+
+/* How much sooner an item of the cow is eaten if it is stolen */
+func stealGain(e herdEntry, avgEat time.Duration) time.Duration {
+	wait := time.Duration(e.QueueLen) * avgEat
+	return wait - e.RTT - avgEat
+}
+
+func (c *Cow) pickByLatency(cows []herdEntry) (string, int, bool) {
+	own := c.Stats().AvgEat
+	var best, unknown herdEntry
+	var bestGain time.Duration
+	stealable := false
+	for _, e := range cows {
+		if e.Stealable == 0 {
+			continue
+		}
+		stealable = true
+		avgEat := e.AvgEat
+		if avgEat == 0 {
+			avgEat = own
+		}
+		if avgEat == 0 {
+			if e.Stealable > unknown.Stealable {
+				unknown = e
+			}
+			continue
+		}
+		if gain := stealGain(e, avgEat); gain > 0 && (best.Addr == "" || gain > bestGain) {
+			best, bestGain = e, gain
+		}
+	}
+	if best.Addr == "" {
+		best = unknown
+	}
+	return best.Addr, best.Stealable, stealable && best.Addr == ""
+}
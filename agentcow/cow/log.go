@@ -0,0 +1,120 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+/*
+ * Leveled, structured logging.
+ *
+ * Every thread of a cow logs through the logger of its subsystem, which
+ * tags each record with subsystem=<name> and has its own level, so that
+ * e.g. eat can be silenced while forage is debugged. Records are written
+ * as text (key=value) or JSON.
+ */
+
+const (
+	SubsysDiscover = "discover"
+	SubsysWander   = "wander"
+	SubsysEat      = "eat"
+	SubsysForage   = "forage"
+	SubsysSow      = "sow"
+	SubsysMoo      = "moo"
+)
+
+var Subsystems = []string{SubsysDiscover, SubsysWander, SubsysEat, SubsysForage, SubsysSow, SubsysMoo}
+
+type LogConfig struct {
+	Output io.Writer             /* defaults to os.Stdout */
+	JSON   bool                  /* JSON records instead of text */
+	Level  slog.Level            /* level of all subsystems, defaults to info */
+	Levels map[string]slog.Level /* per subsystem levels, override Level */
+}
+
+type Logger struct {
+	handler slog.Handler
+
+	mutex  sync.Mutex
+	levels map[string]*slog.LevelVar /* by subsystem */
+}
+
+func NewLogger(config LogConfig) *Logger {
+	out := config.Output
+	if out == nil {
+		out = os.Stdout
+	}
+
+	/* Levels are filtered per subsystem, let everything through here */
+	hopts := &slog.HandlerOptions{Level: slog.Level(-1 << 10)}
+	var handler slog.Handler
+	if config.JSON {
+		handler = slog.NewJSONHandler(out, hopts)
+	} else {
+		handler = slog.NewTextHandler(out, hopts)
+	}
+	l := &Logger{handler: handler, levels: make(map[string]*slog.LevelVar)}
+	l.SetLevels(config.Level, config.Levels)
+	return l
+}
+
+/*
+ * Change the level of all subsystems, levels overrides it for some.
+ * Loggers already handed out by Subsystem follow the change.
+ */
+func (l *Logger) SetLevels(level slog.Level, levels map[string]slog.Level) {
+	for _, subsys := range Subsystems {
+		if sl, ok := levels[subsys]; ok {
+			l.levelVar(subsys).Set(sl)
+		} else {
+			l.levelVar(subsys).Set(level)
+		}
+	}
+}
+
+func (l *Logger) levelVar(subsys string) *slog.LevelVar {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	lv, ok := l.levels[subsys]
+	if !ok {
+		lv = new(slog.LevelVar)
+		l.levels[subsys] = lv
+	}
+	return lv
+}
+
+/* Logger that discards everything */
+func DiscardLogger() *Logger {
+	return NewLogger(LogConfig{Output: io.Discard})
+}
+
+/* Logger for the given subsystem */
+func (l *Logger) Subsystem(name string) *slog.Logger {
+	return slog.New(&levelHandler{l.levelVar(name), l.handler}).With("subsystem", name)
+}
+
+/* Drops records below its level, whatever the wrapped handler would accept */
+type levelHandler struct {
+	level   slog.Leveler
+	handler slog.Handler
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{h.level, h.handler.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{h.level, h.handler.WithGroup(name)}
+}
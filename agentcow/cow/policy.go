@@ -0,0 +1,84 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+/*
+ * Forage policies decide which cow a hungry cow steals from.
+ *
+ * max:     the cow with the most items this cow can steal (default)
+ * random:  a random cow among those with items this cow can steal
+ * latency: the cow where an item waits longest beyond the time it takes
+ *          to steal it, see latency.go
+ */
+
+const (
+	ForageMax     = "max"
+	ForageRandom  = "random"
+	ForageLatency = "latency"
+)
+
+func ValidForagePolicy(policy string) error {
+	switch policy {
+	case ForageMax, ForageRandom, ForageLatency:
+		return nil
+	}
+	return fmt.Errorf("unknown forage policy %q", policy)
+}
+
+func (c *Cow) ForagePolicy() string {
+	c.settingsMutex.Lock()
+	defer c.settingsMutex.Unlock()
+	return c.foragePolicy
+}
+
+/* Change the forage policy of a running cow */
+func (c *Cow) SetForagePolicy(policy string) error {
+	if err := ValidForagePolicy(policy); err != nil {
+		return err
+	}
+	c.settingsMutex.Lock()
+	c.foragePolicy = policy
+	c.settingsMutex.Unlock()
+	return nil
+}
+
+/*
+ * Pick the cow to steal from according to the forage policy.
+ * Returns the number of items it has for this cow, 0 if no cow has any or
+ * the policy declined all that have some, which sets declined.
+ */
+func (c *Cow) pickVictim(cows []herdEntry) (victim string, n int, declined bool) {
+	switch c.ForagePolicy() {
+	case ForageLatency:
+		return c.pickByLatency(cows)
+
+	case ForageRandom:
+		var candidates []herdEntry
+		for _, e := range cows {
+			if e.Stealable > 0 {
+				candidates = append(candidates, e)
+			}
+		}
+		if len(candidates) == 0 {
+			return "", 0, false
+		}
+		e := candidates[rand.Intn(len(candidates))]
+		return e.Addr, e.Stealable, false
+
+	default:
+		var max int = cows[0].Stealable
+		var maxcowaddr string = cows[0].Addr
+
+		for i := 1; i < len(cows); i++ {
+			if max < cows[i].Stealable {
+				max = cows[i].Stealable
+				maxcowaddr = cows[i].Addr
+			}
+		}
+		return maxcowaddr, max, false
+	}
+}
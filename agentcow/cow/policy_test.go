@@ -0,0 +1,30 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"testing"
+	"time"
+)
+
+/* The latency policy tells declining a cow apart from there being nothing to steal */
+func TestPickByLatency(t *testing.T) {
+	c := New(Options{ForagePolicy: ForageLatency, Logger: DiscardLogger()})
+	near := herdEntry{Addr: "near", Stealable: 40, QueueLen: 40, AvgEat: 10 * time.Millisecond, RTT: time.Millisecond}
+	far := herdEntry{Addr: "far", Stealable: 2, QueueLen: 2, AvgEat: 10 * time.Millisecond, RTT: 100 * time.Millisecond}
+	empty := herdEntry{Addr: "empty", QueueLen: 5, AvgEat: 10 * time.Millisecond}
+
+	for _, tc := range []struct {
+		cows     []herdEntry
+		victim   string
+		declined bool
+	}{
+		{[]herdEntry{far, near, empty}, "near", false},
+		{[]herdEntry{far, empty}, "", true},
+		{[]herdEntry{empty}, "", false},
+	} {
+		victim, _, declined := c.pickVictim(tc.cows)
+		if victim != tc.victim || declined != tc.declined {
+			t.Errorf("picked %q declined %v from %v, want %q declined %v", victim, declined, tc.cows, tc.victim, tc.declined)
+		}
+	}
+}
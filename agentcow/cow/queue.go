@@ -0,0 +1,100 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"container/list"
+	"sync"
+)
+
+/*
+ * Queue is the work queue of a cow. It is shared by the cow eating items
+ * off it and by other cows stealing items from it, so implementations must
+ * be safe for concurrent use.
+ *
+ * can reports whether the cow taking an item is able to eat it.
+ */
+type Queue interface {
+	Push(work WorkItem)
+
+	/* Take the first item the cow itself can eat */
+	Pop(can func(WorkItem) bool) (WorkItem, bool)
+
+	/*
+	 * Take an item a thief can eat, as long as it was sown locally. Items
+	 * that were themselves stolen are not handed out again.
+	 */
+	Steal(can func(WorkItem) bool) (WorkItem, bool)
+
+	/* Number of items Steal could hand out */
+	Stealable(can func(WorkItem) bool) int
+
+	Len() int
+}
+
+/*
+ * listQueue is a FIFO work queue, a mutex guarded list. Thieves take the
+ * first item they can eat, and none once they reach a stolen one.
+ */
+type listQueue struct {
+	mutex sync.Mutex
+	list  list.List
+}
+
+func NewListQueue() Queue {
+	return &listQueue{}
+}
+
+func (q *listQueue) Push(work WorkItem) {
+	q.mutex.Lock()
+	q.list.PushBack(work)
+	q.mutex.Unlock()
+}
+
+func (q *listQueue) Pop(can func(WorkItem) bool) (WorkItem, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for e := q.list.Front(); e != nil; e = e.Next() {
+		work := e.Value.(WorkItem)
+		if can(work) {
+			q.list.Remove(e)
+			return work, true
+		}
+	}
+	return WorkItem{}, false
+}
+
+func (q *listQueue) Steal(can func(WorkItem) bool) (WorkItem, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for e := q.list.Front(); e != nil; e = e.Next() {
+		work := e.Value.(WorkItem)
+		if !can(work) {
+			continue
+		}
+		if work.Origin != OriginLocal {
+			break
+		}
+		q.list.Remove(e)
+		return work, true
+	}
+	return WorkItem{}, false
+}
+
+func (q *listQueue) Stealable(can func(WorkItem) bool) int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	n := 0
+	for e := q.list.Front(); e != nil; e = e.Next() {
+		work := e.Value.(WorkItem)
+		if work.Origin == OriginLocal && can(work) {
+			n++
+		}
+	}
+	return n
+}
+
+func (q *listQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.list.Len()
+}
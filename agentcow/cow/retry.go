@@ -0,0 +1,120 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"math/rand"
+	"time"
+)
+
+/*
+ * Retries and the dead-letter list.
+ *
+ * When the executor fails on an item, the item is retried after a backoff
+ * that doubles with every failed attempt: it is queued again with its
+ * NotBefore time set to the end of the backoff. With Options.RetryElsewhere
+ * the retry is handed to another cow (CowRPC.PutWorkItem), falling back to
+ * this cow if none takes it. After MaxAttempts failed attempts the item
+ * goes to the dead-letter list, served by CowRPC.GetDeadLetters. Items
+ * depending on a dead item follow it there after Options.DependencyTimeout,
+ * see deps.go.
+ */
+
+const defMaxAttempts = 3
+const defRetryBackoff = time.Second
+const maxRetryBackoff = 5 * time.Minute
+
+type DeadLetter struct {
+	Item WorkItem
+	Err  string /* error of the last attempt */
+	Time time.Time
+}
+
+/* Items that failed MaxAttempts times on this cow */
+func (c *Cow) DeadLetters() []DeadLetter {
+	c.retryMutex.Lock()
+	defer c.retryMutex.Unlock()
+	return append([]DeadLetter(nil), c.deadLetters...)
+}
+
+func (c *Cow) maxAttempts(work WorkItem) int {
+	if work.MaxAttempts > 0 {
+		return work.MaxAttempts
+	}
+	return c.opts.MaxAttempts
+}
+
+/* Backoff before the given attempt (1 is the first retry) */
+func (c *Cow) retryBackoff(attempt int) time.Duration {
+	backoff := c.opts.RetryBackoff
+	for i := 1; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	return backoff
+}
+
+/* The executor failed on an item, retry it or give up on it */
+func (c *Cow) failed(work WorkItem, err error) {
+	log := c.Log(SubsysEat)
+	work.Attempts++
+	c.statsMutex.Lock()
+	c.stats.Failed++
+	c.statsMutex.Unlock()
+
+	if work.Attempts >= c.maxAttempts(work) {
+		log.Error("giving up on work", "id", work.ID, "attempts", work.Attempts, "err", err)
+		c.deadLetter(work, err)
+		return
+	}
+
+	backoff := c.retryBackoff(work.Attempts)
+	log.Warn("work failed, will retry", "id", work.ID, "attempts", work.Attempts, "backoff", backoff, "err", err)
+	work.NotBefore = time.Now().Add(backoff)
+	c.reportJob(work, JobQueued)
+
+	if c.opts.RetryElsewhere {
+		if cowaddr, ok := c.handOver(work); ok {
+			c.sent(work.ID, cowaddr)
+			c.event(EventRetry, work.ID, cowaddr)
+			return
+		}
+	}
+	c.enqueue(work)
+	c.event(EventRetry, work.ID, "")
+}
+
+func (c *Cow) deadLetter(work WorkItem, err error) {
+	c.retryMutex.Lock()
+	c.deadLetters = append(c.deadLetters, DeadLetter{work, err.Error(), time.Now()})
+	c.retryMutex.Unlock()
+	c.event(EventDeadLetter, work.ID, "")
+	c.reportJob(work, JobFailed)
+}
+
+/* Hand an item to a random cow that is alive, returns its address */
+func (c *Cow) handOver(work WorkItem) (string, bool) {
+	var alive []string
+	c.peerMutex.Lock()
+	for _, cowaddr := range c.herd.cows() {
+		if p, ok := c.peers[cowaddr]; ok && p.State == stateAlive {
+			alive = append(alive, cowaddr)
+		}
+	}
+	c.peerMutex.Unlock()
+
+	for _, i := range rand.Perm(len(alive)) {
+		client, err := c.dial(alive[i])
+		if err != nil {
+			continue
+		}
+		var accepted bool
+		err = client.Call("CowRPC.PutWorkItem", &PutArgs{work, c.opts.Addr}, &accepted)
+		client.Close()
+		if err == nil && accepted {
+			return alive[i], true
+		}
+	}
+	return "", false
+}
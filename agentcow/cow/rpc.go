@@ -0,0 +1,164 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+/*
+ * RPCs served by a cow to the other cows of the herd.
+ */
+
+type ArgsNotUsed int
+
+/* Args of RPCs that take none, for Chaos.Partition. Programs other than cows leave From empty. */
+type Caller struct {
+	From string /* RPC address of the calling cow */
+}
+
+/* A retry handed over by another cow */
+type PutArgs struct {
+	Item WorkItem
+	From string
+}
+
+type CowRPC struct {
+	c *Cow
+}
+
+/* Sent by a thief, so that only items it can eat are handed out */
+type StealArgs struct {
+	Labels []string
+	Thief  string   /* RPC address of the thief */
+	Hops   int      /* how often the request may still be forwarded, see view.go */
+	Path   []string /* cows that forwarded the request */
+}
+
+/* The cow that sent the request, the last to forward it or the thief */
+func (a *StealArgs) caller() string {
+	if len(a.Path) != 0 {
+		return a.Path[len(a.Path)-1]
+	}
+	return a.Thief
+}
+
+func (t *CowRPC) GetQueueLen(args *Caller, reply *int) error {
+	if t.c.partitioned(args.From) {
+		return errChaosPartition
+	}
+	*reply = t.c.queue.Len()
+	return nil
+}
+
+func (t *CowRPC) GetStealableLen(args *StealArgs, reply *int) error {
+	if t.c.partitioned(args.Thief) {
+		return errChaosPartition
+	}
+	if !t.c.Forageable() {
+		*reply = 0
+		return nil
+	}
+	*reply = t.c.queue.Stealable(t.thief(args.Labels))
+	return nil
+}
+
+/*
+ * Hand out the first item the thief can eat, as long as it was sown
+ * locally. Remote items are not handed out again, nor are blocked items.
+ */
+func (t *CowRPC) GetWorkItem(args *StealArgs, reply *WorkItem) error {
+	if t.c.partitioned(args.caller()) {
+		return errChaosPartition
+	}
+	if !t.c.Forageable() {
+		*reply = WorkItem{}
+		return nil
+	}
+	work, ok := t.c.queue.Steal(t.thief(args.Labels))
+	if ok {
+		t.c.statsMutex.Lock()
+		t.c.stats.StolenOut++
+		t.c.statsMutex.Unlock()
+		t.c.sent(work.ID, args.Thief)
+		t.c.event(EventStealOut, work.ID, args.Thief)
+	} else if args.Hops > 0 {
+		work = t.c.forwardSteal(args)
+	}
+	*reply = work
+	return nil
+}
+
+/* Swap samples of the views, see view.go */
+func (t *CowRPC) Shuffle(args *ShuffleArgs, reply *[]string) error {
+	if t.c.partitioned(args.From) {
+		return errChaosPartition
+	}
+	*reply = t.c.viewSample(len(args.Cows))
+	t.c.mergeView(t.c.ctx, args.Cows)
+	return nil
+}
+
+func (t *CowRPC) GetStats(args *Caller, reply *CowStats) error {
+	if t.c.partitioned(args.From) {
+		return errChaosPartition
+	}
+	*reply = t.c.cowStats()
+	return nil
+}
+
+/* Item offered by a cow over its shed threshold, see balance.go */
+func (t *CowRPC) Offer(args *OfferArgs, reply *bool) error {
+	if t.c.partitioned(args.From) {
+		return errChaosPartition
+	}
+	*reply = t.c.accept(args)
+	return nil
+}
+
+/* Take over a retry from another cow, as long as this cow can eat it */
+func (t *CowRPC) PutWorkItem(args *PutArgs, reply *bool) error {
+	if t.c.partitioned(args.From) {
+		return errChaosPartition
+	}
+	w := args.Item
+	if !canRun(t.c.opts.Labels, w) {
+		*reply = false
+		return nil
+	}
+	w.Origin = OriginRemote
+	/* The item was eaten, so its dependencies completed */
+	t.c.markCompleted(w.DependsOn...)
+	t.c.enqueue(w)
+	t.c.event(EventEnqueue, w.ID, "")
+	*reply = true
+	return nil
+}
+
+func (t *CowRPC) GetDeadLetters(args *Caller, reply *[]DeadLetter) error {
+	if t.c.partitioned(args.From) {
+		return errChaosPartition
+	}
+	*reply = t.c.DeadLetters()
+	return nil
+}
+
+/* The other copy of an item was eaten by the caller, see speculate.go */
+func (t *CowRPC) Completed(args *ItemIDs, _ *ArgsNotUsed) error {
+	if t.c.partitioned(args.From) {
+		return errChaosPartition
+	}
+	t.c.markCompleted(args.IDs...)
+	t.c.overtake(args.IDs)
+	return nil
+}
+
+/* Of the items the caller awaits, those this cow knows to be completed */
+func (t *CowRPC) GetCompleted(args *ItemIDs, reply *ItemIDs) error {
+	if t.c.partitioned(args.From) {
+		return errChaosPartition
+	}
+	reply.IDs = t.c.completedOf(args.IDs)
+	return nil
+}
+
+func (t *CowRPC) thief(labels []string) func(WorkItem) bool {
+	return func(work WorkItem) bool {
+		return canRun(labels, work) && t.c.ready(work)
+	}
+}
@@ -0,0 +1,166 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+ * Delayed and recurring work items.
+ *
+ * An item with a NotBefore time in the future is held back, out of the
+ * work queue, until then: it can neither be eaten nor stolen early. The
+ * release thread moves due items to the work queue.
+ *
+ * Recur submits a copy of an item every period, like a cron job, and is
+ * also served as CowAdmin.Recur: not to other cows, which could fill the
+ * cow with schedules. Each copy is given the ID
+ * <schedule ID>@<unix time in ms it is due>.
+ */
+
+type Schedule struct {
+	ID    string        /* unique on this cow */
+	Every time.Duration /* period between two submissions */
+	Start time.Time     /* first submission, zero means now */
+	Item  WorkItem      /* submitted every period */
+}
+
+/* Items held until their NotBefore time, earliest first */
+type heldItems []WorkItem
+
+func (h heldItems) Len() int            { return len(h) }
+func (h heldItems) Less(i, j int) bool  { return h[i].NotBefore.Before(h[j].NotBefore) }
+func (h heldItems) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *heldItems) Push(x interface{}) { *h = append(*h, x.(WorkItem)) }
+func (h *heldItems) Pop() interface{} {
+	old := *h
+	work := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return work
+}
+
+/* Add an item to the work queue, or hold it if it is not due yet */
+func (c *Cow) enqueue(work WorkItem) {
+	if time.Now().Before(work.NotBefore) {
+		c.holdMutex.Lock()
+		heap.Push(&c.held, work)
+		c.holdMutex.Unlock()
+		select {
+		case c.holdWake <- struct{}{}:
+		default:
+		}
+		return
+	}
+	c.queue.Push(work)
+}
+
+/* Number of items held until their NotBefore time */
+func (c *Cow) HeldLen() int {
+	c.holdMutex.Lock()
+	defer c.holdMutex.Unlock()
+	return len(c.held)
+}
+
+/* Move held items to the work queue when they are due */
+func (c *Cow) release(ctx context.Context) {
+	defer c.wg.Done()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		next := time.Hour
+		c.holdMutex.Lock()
+		for len(c.held) > 0 {
+			wait := time.Until(c.held[0].NotBefore)
+			if wait > 0 {
+				next = wait
+				break
+			}
+			work := heap.Pop(&c.held).(WorkItem)
+			c.queue.Push(work)
+			c.event(EventRelease, work.ID, "")
+		}
+		c.holdMutex.Unlock()
+
+		timer.Reset(next)
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.holdWake:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+		}
+	}
+}
+
+/* Submit a copy of s.Item every s.Every, until StopRecurring or Stop */
+func (c *Cow) Recur(s Schedule) error {
+	if s.ID == "" || s.Every <= 0 {
+		return errors.New("a schedule needs an ID and a positive period")
+	}
+	now := time.Now()
+	if s.Start.IsZero() {
+		s.Start = now
+	}
+	/* Occurrences missed in the past are not caught up on */
+	if missed := now.Sub(s.Start) / s.Every; missed > 0 {
+		s.Start = s.Start.Add(missed * s.Every)
+	}
+
+	c.holdMutex.Lock()
+	defer c.holdMutex.Unlock()
+	if _, ok := c.schedules[s.ID]; ok {
+		return fmt.Errorf("schedule %s already exists", s.ID)
+	}
+	c.schedules[s.ID] = &s
+	c.submitOccurrence(&s, s.Start)
+	return nil
+}
+
+/* Stop submitting items of a schedule, items already submitted stay */
+func (c *Cow) StopRecurring(id string) {
+	c.holdMutex.Lock()
+	delete(c.schedules, id)
+	c.holdMutex.Unlock()
+}
+
+/*
+ * Hold the occurrence of a schedule due at the given time, and arrange for
+ * the next one to be held once it is due. Called with holdMutex held.
+ */
+func (c *Cow) submitOccurrence(s *Schedule, due time.Time) {
+	work := s.Item
+	work.ID = fmt.Sprintf("%s@%d", s.ID, due.UnixMilli())
+	work.Origin = OriginLocal
+	work.NotBefore = due
+	heap.Push(&c.held, work)
+	c.event(EventEnqueue, work.ID, "")
+
+	time.AfterFunc(time.Until(due), func() {
+		c.holdMutex.Lock()
+		defer c.holdMutex.Unlock()
+		if c.schedules[s.ID] == s {
+			c.submitOccurrence(s, due.Add(s.Every))
+		}
+	})
+	select {
+	case c.holdWake <- struct{}{}:
+	default:
+	}
+}
+
+/* Recurring submissions, see Cow.Recur */
+func (a *CowAdmin) Recur(s *Schedule, _ *ArgsNotUsed) error {
+	return a.c.Recur(*s)
+}
+
+func (a *CowAdmin) StopRecurring(id *string, _ *ArgsNotUsed) error {
+	a.c.StopRecurring(*id)
+	return nil
+}
@@ -0,0 +1,180 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+/*
+ * Speculative execution of stragglers.
+ *
+ * Every cow keeps a registry of the items it is eating, served as
+ * CowRPC.GetRunning. With Options.Speculate set, an item that has been
+ * running longer than Speculate times its estimate (Duration in units of
+ * Options.DurationUnit) is a straggler. A cow with nothing to eat looks
+ * through the registries of the cows it knows, at most once per wander
+ * interval, and asks the owner of a straggler for a copy of it
+ * (CowRPC.Speculate). An item gets one copy at most.
+ *
+ * Whichever run finishes first completes the item, and the completion
+ * (CowRPC.Completed, see deps.go) cancels the other run, which neither
+ * fails nor completes. A copy that fails is dropped, the original keeps
+ * running. Both runs can finish at about the same time, so executors of
+ * speculating cows should be idempotent.
+ */
+
+const defDurationUnit = time.Second
+
+var errOvertaken = errors.New("eaten by another cow first")
+
+/* Item being eaten, as served by CowRPC.GetRunning */
+type RunningItem struct {
+	Item      WorkItem
+	Running   time.Duration /* so far */
+	Straggler bool
+	Copy      string /* RPC address of the cow running the other copy, if any */
+}
+
+type SpeculateArgs struct {
+	ID    string
+	Thief string /* RPC address of the cow that will run the copy */
+}
+
+type runningItem struct {
+	item   WorkItem
+	start  time.Time
+	cancel context.CancelCauseFunc
+	isCopy bool
+	copy   string /* the owner for a copy, the cow running the copy for the original */
+}
+
+/* Has the item run much longer than estimated */
+func (c *Cow) straggler(r *runningItem) bool {
+	estimate := time.Duration(r.item.Duration) * c.opts.DurationUnit
+	return c.opts.Speculate > 0 && estimate > 0 && time.Since(r.start) > time.Duration(c.opts.Speculate*float64(estimate))
+}
+
+/* Items this cow is eating */
+func (c *Cow) Running() []RunningItem {
+	c.runningMutex.Lock()
+	defer c.runningMutex.Unlock()
+	items := make([]RunningItem, 0, len(c.running))
+	for _, r := range c.running {
+		items = append(items, RunningItem{Item: r.item, Running: time.Since(r.start), Straggler: c.straggler(r), Copy: r.copy})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Item.ID < items[j].Item.ID })
+	return items
+}
+
+/* Register an item being eaten, owner is set for a copy */
+func (c *Cow) startRunning(work WorkItem, cancel context.CancelCauseFunc, owner string) {
+	if work.ID == "" {
+		return
+	}
+	c.runningMutex.Lock()
+	c.running[work.ID] = &runningItem{item: work, start: time.Now(), cancel: cancel, isCopy: owner != "", copy: owner}
+	c.runningMutex.Unlock()
+}
+
+/* Unregister an item, returns the cow running the other copy */
+func (c *Cow) stopRunning(id string) string {
+	c.runningMutex.Lock()
+	defer c.runningMutex.Unlock()
+	r, ok := c.running[id]
+	if !ok {
+		return ""
+	}
+	delete(c.running, id)
+	return r.copy
+}
+
+/* Cancel the runs of items that completed elsewhere */
+func (c *Cow) overtake(ids []string) {
+	c.runningMutex.Lock()
+	defer c.runningMutex.Unlock()
+	for _, id := range ids {
+		if r, ok := c.running[id]; ok {
+			r.cancel(errOvertaken)
+		}
+	}
+}
+
+/* Hand out a copy of a straggler, unless it has one */
+func (c *Cow) handCopy(args *SpeculateArgs) WorkItem {
+	c.runningMutex.Lock()
+	defer c.runningMutex.Unlock()
+	r, ok := c.running[args.ID]
+	if !ok || r.isCopy || r.copy != "" || !c.straggler(r) {
+		return WorkItem{}
+	}
+	r.copy = args.Thief
+	c.Log(SubsysForage).Info("handed out copy of straggler", "id", args.ID, "to", args.Thief, "running", time.Since(r.start))
+	return r.item
+}
+
+/*
+ * Look for a straggler of another cow and eat a copy of it, returns false
+ * if there was none.
+ */
+func (c *Cow) speculate(ctx context.Context) bool {
+	if c.opts.Speculate <= 0 || time.Since(c.lastSpeculate) < c.opts.WanderInterval {
+		return false
+	}
+	c.lastSpeculate = time.Now()
+
+	cows := c.freshCows()
+	rand.Shuffle(len(cows), func(i, j int) { cows[i], cows[j] = cows[j], cows[i] })
+	for _, e := range cows {
+		client, err := c.dial(e.Addr)
+		if err != nil {
+			continue
+		}
+		var running []RunningItem
+		var work WorkItem
+		if client.Call("CowRPC.GetRunning", &Caller{c.opts.Addr}, &running) == nil {
+			for _, r := range running {
+				if !r.Straggler || r.Copy != "" || !canRun(c.opts.Labels, r.Item) || c.Completed(r.Item.ID) {
+					continue
+				}
+				if client.Call("CowRPC.Speculate", &SpeculateArgs{ID: r.Item.ID, Thief: c.opts.Addr}, &work) == nil && !work.empty() {
+					break
+				}
+			}
+		}
+		client.Close()
+
+		if !work.empty() {
+			c.statsMutex.Lock()
+			c.stats.Speculated++
+			c.statsMutex.Unlock()
+			c.Log(SubsysEat).Info("speculating on straggler", "id", work.ID, "from", e.Addr)
+			c.event(EventSpeculate, work.ID, e.Addr)
+			work.Origin = OriginRemote
+			c.eatItem(ctx, work, e.Addr)
+			return true
+		}
+	}
+	return false
+}
+
+/* Items being eaten by this cow */
+func (t *CowRPC) GetRunning(args *Caller, reply *[]RunningItem) error {
+	if t.c.partitioned(args.From) {
+		return errChaosPartition
+	}
+	*reply = t.c.Running()
+	return nil
+}
+
+/* Hand out a copy of a straggler, an empty item if it has one already or is done */
+func (t *CowRPC) Speculate(args *SpeculateArgs, reply *WorkItem) error {
+	if t.c.partitioned(args.Thief) {
+		return errChaosPartition
+	}
+	*reply = t.c.handCopy(args)
+	return nil
+}
@@ -0,0 +1,111 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/rpc"
+	"time"
+)
+
+/*
+ * Transport carries RPCs between the cows of a herd.
+ */
+type Transport interface {
+	/* Start serving RPCs from other cows with srv, returns once listening */
+	Serve(srv *rpc.Server) (io.Closer, error)
+
+	/* Connect to the RPC server of the cow at addr */
+	Dial(addr string) (*rpc.Client, error)
+}
+
+/*
+ * HTTPTransport serves RPCs over HTTP, the way net/rpc does, optionally
+ * over mutual TLS.
+ */
+type HTTPTransport struct {
+	Listen      string        /* address to listen on, e.g. DefaultPort */
+	TLS         *tls.Config   /* nil for plain TCP, see LoadTLSConfig */
+	DialTimeout time.Duration /* 0 means no timeout */
+
+	handlers map[string]http.Handler /* by pattern */
+}
+
+/*
+ * Serve an HTTP handler next to the RPCs, e.g. the dashboard. Call before
+ * Serve, a handler for the same pattern replaces the earlier one.
+ */
+func (t *HTTPTransport) Handle(pattern string, handler http.Handler) {
+	if t.handlers == nil {
+		t.handlers = make(map[string]http.Handler)
+	}
+	t.handlers[pattern] = handler
+}
+
+/* Every Serve builds its own mux, so that a cow whose Start failed can start again */
+func (t *HTTPTransport) Serve(srv *rpc.Server) (io.Closer, error) {
+	var listener net.Listener
+	var err error
+	if t.TLS != nil {
+		listener, err = tls.Listen("tcp", t.Listen, t.TLS)
+	} else {
+		listener, err = net.Listen("tcp", t.Listen)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	for pattern, handler := range t.handlers {
+		mux.Handle(pattern, handler)
+	}
+	mux.Handle(rpc.DefaultRPCPath, srv)
+	go http.Serve(listener, mux)
+	return listener, nil
+}
+
+/*
+ * Same as rpc.DialHTTP, but over TLS when enabled and with a timeout
+ * covering both connecting and the HTTP handshake.
+ */
+func (t *HTTPTransport) Dial(addr string) (*rpc.Client, error) {
+	dialer := &net.Dialer{Timeout: t.DialTimeout}
+
+	var conn net.Conn
+	var err error
+	if t.TLS == nil {
+		conn, err = dialer.Dial("tcp", addr)
+	} else {
+		var host string
+		host, _, err = net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		config := t.TLS.Clone()
+		config.ServerName = stripZone(host)
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if t.DialTimeout != 0 {
+		conn.SetDeadline(time.Now().Add(t.DialTimeout))
+	}
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.0\n\n", rpc.DefaultRPCPath)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err == nil && resp.Status != "200 Connected to Go RPC" {
+		err = errors.New("unexpected HTTP response: " + resp.Status)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return rpc.NewClient(conn), nil
+}
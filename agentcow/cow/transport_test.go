@@ -0,0 +1,38 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+/* A cow whose Start failed, here on an address in use, can start again */
+func TestStartAgain(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	c := New(Options{Addr: addr, Transport: &HTTPTransport{Listen: addr}, Logger: DiscardLogger()})
+
+	if err := c.Start(context.Background()); err == nil {
+		c.Stop()
+		t.Fatal("started on an address in use")
+	}
+	listener.Close()
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	client, err := c.opts.Transport.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var n int
+	if err := client.Call("CowRPC.GetStealableLen", &StealArgs{Thief: "thief"}, &n); err != nil || n != 0 {
+		t.Errorf("%d stealable items, %v", n, err)
+	}
+}
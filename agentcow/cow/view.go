@@ -0,0 +1,180 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package cow
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+/*
+ * Partial views, for herds of hundreds of cows.
+ *
+ * With Options.ViewSize set, a cow knows at most that many cows. Every
+ * shuffle interval it swaps a random sample of the cows it knows, itself
+ * included, with a random cow it knows (CowRPC.Shuffle). Both keep the
+ * cows they did not know, dropping random ones to stay within their view
+ * size, so that views keep being random samples of the herd (Cyclon-like
+ * peer sampling). Discovery and Peers only fill a view that is not full.
+ *
+ * A cow whose view has nothing to steal sends a steal request with
+ * Options.MaxHops hops to a random cow it knows. A cow that has nothing to
+ * hand out forwards the request to the cow its forage policy picks, or a
+ * random one if it knows none with items, until the hops run out. It
+ * drops the request if the policy declines all of them. The item goes
+ * back along the same path.
+ */
+
+const defShuffleWanders = 5
+const defMaxHops = 3
+
+/* Can another cow be added without dropping one */
+func (c *Cow) viewFull() bool {
+	return c.opts.ViewSize > 0 && c.herd.len() >= c.opts.ViewSize
+}
+
+/* Drop a cow from the herd table, its wander thread exits */
+func (c *Cow) dropCow(cowaddr string) bool {
+	if !c.herd.remove(cowaddr) {
+		return false
+	}
+	c.peerMutex.Lock()
+	delete(c.peers, cowaddr)
+	c.peerMutex.Unlock()
+	c.event(EventPeerLost, "", cowaddr)
+	return true
+}
+
+/* Random sample of n known cows, and this cow */
+func (c *Cow) viewSample(n int) []string {
+	cows := c.herd.cows()
+	rand.Shuffle(len(cows), func(i, j int) { cows[i], cows[j] = cows[j], cows[i] })
+	if len(cows) > n {
+		cows = cows[:n]
+	}
+	return append(cows, c.opts.Addr)
+}
+
+/* Add the cows of a sample this cow does not know, dropping random ones if the view is full */
+func (c *Cow) mergeView(ctx context.Context, sample []string) {
+	added := make(map[string]bool)
+	for _, cowaddr := range sample {
+		if cowaddr == c.opts.Addr || c.herd.known(cowaddr) || c.isEvicted(cowaddr) {
+			continue
+		}
+		if c.viewFull() {
+			var victims []string
+			for _, addr := range c.herd.cows() {
+				if !added[addr] {
+					victims = append(victims, addr)
+				}
+			}
+			if len(victims) == 0 {
+				return
+			}
+			c.dropCow(victims[rand.Intn(len(victims))])
+		}
+		c.addCow(ctx, cowaddr, cowaddr)
+		added[cowaddr] = true
+	}
+}
+
+/* Swap samples of the view with a random known cow */
+func (c *Cow) shuffle(ctx context.Context) {
+	defer c.wg.Done()
+	log := c.Log(SubsysDiscover)
+	log.Info("launched shuffle thread", "view", c.opts.ViewSize)
+
+	for sleep(ctx, c.opts.ShuffleInterval) {
+		cows := c.herd.cows()
+		if len(cows) == 0 {
+			continue
+		}
+		cowaddr := cows[rand.Intn(len(cows))]
+		client, err := c.dial(cowaddr)
+		if err != nil {
+			/* Make room for cows that can be reached */
+			c.dropCow(cowaddr)
+			continue
+		}
+		var reply []string
+		err = client.Call("CowRPC.Shuffle", &ShuffleArgs{c.opts.Addr, c.viewSample(c.opts.ViewSize / 2)}, &reply)
+		client.Close()
+		if err == nil {
+			c.mergeView(ctx, reply)
+			log.Debug("shuffled view", "peer", cowaddr, "cows", c.herd.len())
+		}
+	}
+}
+
+type ShuffleArgs struct {
+	From string
+	Cows []string /* sample of the view of From, From included */
+}
+
+/*
+ * Send a steal request on a walk through the herd, when no cow this cow
+ * knows has anything to steal. At most once per wander interval.
+ */
+func (c *Cow) forageFar(cows []herdEntry) (WorkItem, string) {
+	if c.opts.MaxHops <= 0 || len(cows) == 0 || time.Since(c.lastForward) < c.opts.WanderInterval {
+		return WorkItem{}, ""
+	}
+	c.lastForward = time.Now()
+	cowaddr := cows[rand.Intn(len(cows))].Addr
+	return c.steal(cowaddr, c.opts.MaxHops, nil), cowaddr
+}
+
+/* Ask a cow for an item, which it may forward hops times */
+func (c *Cow) steal(cowaddr string, hops int, path []string) WorkItem {
+	var work WorkItem
+	client, err := c.dial(cowaddr)
+	if err != nil {
+		return work
+	}
+	args := StealArgs{Labels: c.opts.Labels, Thief: c.opts.Addr, Hops: hops, Path: path}
+	client.Call("CowRPC.GetWorkItem", &args, &work)
+	client.Close()
+	return work
+}
+
+/* Forward a steal request this cow has nothing for */
+func (c *Cow) forwardSteal(args *StealArgs) WorkItem {
+	skip := map[string]bool{args.Thief: true, c.opts.Addr: true}
+	for _, p := range args.Path {
+		skip[p] = true
+	}
+	var candidates []herdEntry
+	for _, e := range c.freshCows() {
+		if !skip[e.Addr] {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		return WorkItem{}
+	}
+	next, n, declined := c.pickVictim(candidates)
+	if declined {
+		return WorkItem{}
+	}
+	if n == 0 {
+		next = candidates[rand.Intn(len(candidates))].Addr
+	}
+
+	client, err := c.dial(next)
+	if err != nil {
+		return WorkItem{}
+	}
+	var work WorkItem
+	fwd := StealArgs{Labels: args.Labels, Thief: args.Thief, Hops: args.Hops - 1, Path: append(args.Path, c.opts.Addr)}
+	client.Call("CowRPC.GetWorkItem", &fwd, &work)
+	client.Close()
+
+	if !work.empty() {
+		c.statsMutex.Lock()
+		c.stats.Forwarded++
+		c.statsMutex.Unlock()
+		c.event(EventForward, work.ID, next)
+	}
+	return work
+}
@@ -0,0 +1,102 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package main
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+/*
+ * Distributions used by the load generators (sow and sowToFile).
+ *
+ * Arrival distributions decide how long sow sleeps before adding the next
+ * work item. Duration distributions decide the Duration of each work item.
+ */
+
+const (
+	arrival_uniform = "uniform"
+	arrival_poisson = "poisson"
+	arrival_burst   = "burst"
+)
+
+const (
+	duration_uniform     = "uniform"
+	duration_exponential = "exponential"
+	duration_pareto      = "pareto"
+	duration_bimodal     = "bimodal"
+)
+
+/* Shape of the pareto distribution, smaller means heavier tail */
+const paretoAlpha = 1.5
+
+/* Fraction of long items generated by the bimodal distribution */
+const bimodalLongFraction = 0.2
+
+/* Random source shared by the load generators, seeded by -seed */
+var sowRand *rand.Rand
+
+func validArrival(name string) bool {
+	switch name {
+	case arrival_uniform, arrival_poisson, arrival_burst:
+		return true
+	}
+	return false
+}
+
+func validDuration(name string) bool {
+	switch name {
+	case duration_uniform, duration_exponential, duration_pareto, duration_bimodal:
+		return true
+	}
+	return false
+}
+
+/*
+ * Return the time sow should sleep before adding work item n.
+ *
+ * uniform: random number of seconds in [0, -max-sow-sleep)
+ * poisson: exponential inter-arrival times with the same mean as uniform
+ * burst:   -burst-size items back to back every -burst-period seconds
+ */
+func nextArrival(n int) time.Duration {
+	switch *arrivalDist {
+	case arrival_poisson:
+		mean := float64(*maxSowSleep-1) / 2
+		return time.Duration(sowRand.ExpFloat64() * mean * float64(time.Second))
+	case arrival_burst:
+		if *burstSize > 0 && n%*burstSize == 0 {
+			return time.Second * time.Duration(*burstPeriod)
+		}
+		return 0
+	default:
+		return time.Second * time.Duration(sowRand.Intn(*maxSowSleep))
+	}
+}
+
+/*
+ * Return the Duration of the next work item.
+ *
+ * uniform:     random number in [0, max]
+ * exponential: exponentially distributed with mean max/2
+ * pareto:      heavy tailed with mean max/2, can exceed max
+ * bimodal:     mostly short items in [0, max/4] with a few long ones in [3*max/4, max]
+ */
+func nextDuration(max int) int {
+	mean := float64(max) / 2
+	switch *durationDist {
+	case duration_exponential:
+		return int(math.Floor(sowRand.ExpFloat64()*mean + 0.5))
+	case duration_pareto:
+		xm := mean * (paretoAlpha - 1) / paretoAlpha
+		u := 1 - sowRand.Float64() /* (0, 1] */
+		return int(math.Floor(xm/math.Pow(u, 1/paretoAlpha) + 0.5))
+	case duration_bimodal:
+		if sowRand.Float64() < bimodalLongFraction {
+			return max - sowRand.Intn(max/4+1)
+		}
+		return sowRand.Intn(max/4 + 1)
+	default:
+		return sowRand.Intn(max + 1)
+	}
+}
@@ -0,0 +1,95 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const distSamples = 5000
+
+/* Draw n values from a distribution with the given seed */
+func draw(seed int64, next func(n int) int) []int {
+	sowRand = rand.New(rand.NewSource(seed))
+	values := make([]int, distSamples)
+	for i := range values {
+		values[i] = next(i)
+	}
+	return values
+}
+
+/* Each duration distribution repeats itself for a seed and stays within its range */
+func TestDurationDistributions(t *testing.T) {
+	defer func(d string) { *durationDist = d }(*durationDist)
+	const max = 40
+
+	for _, tc := range []struct {
+		dist     string
+		min, max int /* -1 for no bound */
+		within   func(v int) bool
+		mean     bool /* is max/2 */
+	}{
+		{duration_uniform, 0, max, nil, true},
+		{duration_exponential, 0, -1, nil, true},
+		{duration_pareto, max / 6, -1, nil, true},
+		{duration_bimodal, 0, max, func(v int) bool { return v <= max/4 || v >= 3*max/4 }, false},
+	} {
+		*durationDist = tc.dist
+		values := draw(7, func(int) int { return nextDuration(max) })
+		if again := draw(7, func(int) int { return nextDuration(max) }); !reflect.DeepEqual(values, again) {
+			t.Errorf("%s: not reproducible for a seed", tc.dist)
+		}
+		if other := draw(8, func(int) int { return nextDuration(max) }); reflect.DeepEqual(values, other) {
+			t.Errorf("%s: same values for another seed", tc.dist)
+		}
+
+		sum := 0
+		for _, v := range values {
+			if v < tc.min || (tc.max >= 0 && v > tc.max) || (tc.within != nil && !tc.within(v)) {
+				t.Errorf("%s: %d out of range", tc.dist, v)
+				break
+			}
+			sum += v
+		}
+		/* Loosely for the heavy tailed pareto */
+		if mean := float64(sum) / distSamples; tc.mean && (mean < max/2*0.8 || mean > max/2*1.2) {
+			t.Errorf("%s: mean %.1f, want about %d", tc.dist, mean, max/2)
+		}
+	}
+}
+
+/* Each arrival distribution repeats itself for a seed and stays within its range */
+func TestArrivalDistributions(t *testing.T) {
+	defer func(d string, sleep, size, period int) {
+		*arrivalDist, *maxSowSleep, *burstSize, *burstPeriod = d, sleep, size, period
+	}(*arrivalDist, *maxSowSleep, *burstSize, *burstPeriod)
+	*maxSowSleep, *burstSize, *burstPeriod = 5, 4, 3
+
+	for _, tc := range []struct {
+		dist  string
+		valid func(n int, d time.Duration) bool
+	}{
+		{arrival_uniform, func(n int, d time.Duration) bool {
+			return d >= 0 && d < 5*time.Second && d%time.Second == 0
+		}},
+		{arrival_poisson, func(n int, d time.Duration) bool { return d >= 0 }},
+		{arrival_burst, func(n int, d time.Duration) bool {
+			return (n%4 == 0 && d == 3*time.Second) || (n%4 != 0 && d == 0)
+		}},
+	} {
+		*arrivalDist = tc.dist
+		next := func(n int) int { return int(nextArrival(n)) }
+		values := draw(7, next)
+		if again := draw(7, next); !reflect.DeepEqual(values, again) {
+			t.Errorf("%s: not reproducible for a seed", tc.dist)
+		}
+		for n, v := range values {
+			if !tc.valid(n, time.Duration(v)) {
+				t.Errorf("%s: item %d after %v", tc.dist, n, time.Duration(v))
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/shubhamat/dgo/agentcow
+
+go 1.21
@@ -0,0 +1,373 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+
+/*
+ * Package herdtest runs a herd of cows in one process, for testing how
+ * well a herd balances its load.
+ *
+ * The cows talk over an in-memory transport (or HTTP on loopback) and know
+ * each other from the start, so no discovery is needed. Every work item
+ * eaten by any cow is recorded, so that a test can check that no item was
+ * lost, none was eaten twice and that the herd drained in time:
+ *
+ *     h, _ := herdtest.Start(ctx, herdtest.Config{Cows: 4})
+ *     defer h.Stop()
+ *     h.Submit(0, h.Workload(100, 5, 1)...)
+ *     if err := h.WaitDrained(10 * time.Second); err != nil { ... }
+ *     if err := h.Check(); err != nil { ... }
+ */
+package herdtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shubhamat/dgo/agentcow/cow"
+)
+
+const defTimeUnit = 10 * time.Millisecond
+const defWanderInterval = 20 * time.Millisecond
+const defIdleSleep = 5 * time.Millisecond
+
+type Config struct {
+	Cows     int
+	Loopback bool          /* HTTP over 127.0.0.1 instead of the in-memory transport */
+	TimeUnit time.Duration /* how long a work item of Duration 1 takes to eat, default 10ms */
+	Logger   *cow.Logger   /* log of all cows, discarded by default */
+
+	/* Makes cow i fail on an item, after holding on to it */
+	Fail func(i int, work cow.WorkItem) bool
+
+	/* Makes cow i hold on to an item until it is cancelled, a straggler */
+	Stall func(i int, work cow.WorkItem) bool
+
+	/* Called with the options of cow i before it is created, to tweak them */
+	Options func(i int, opts *cow.Options)
+}
+
+/* Record of a work item being eaten successfully */
+type Eaten struct {
+	Cow  int
+	Item cow.WorkItem
+	At   time.Time
+}
+
+type Herd struct {
+	Cows []*cow.Cow
+
+	config Config
+	opts   []cow.Options /* the cows were created with */
+	admins []string      /* addresses of Options.Admin */
+
+	mutex     sync.Mutex
+	submitted map[string]int /* items submitted, by ID */
+	eaten     []Eaten
+	nextID    int
+}
+
+/* Create the cows of a herd and start them */
+func Start(ctx context.Context, config Config) (*Herd, error) {
+	if config.Cows < 1 {
+		return nil, errors.New("a herd needs at least one cow")
+	}
+	if config.TimeUnit == 0 {
+		config.TimeUnit = defTimeUnit
+	}
+	if config.Logger == nil {
+		config.Logger = cow.DiscardLogger()
+	}
+
+	h := &Herd{config: config, submitted: make(map[string]int)}
+
+	addrs, err := h.addresses("cow")
+	if err != nil {
+		return nil, err
+	}
+	h.admins, err = h.addresses("admin")
+	if err != nil {
+		return nil, err
+	}
+	network := NewNetwork()
+
+	for i := 0; i < config.Cows; i++ {
+		var peers []string
+		for j, addr := range addrs {
+			if j != i {
+				peers = append(peers, addr)
+			}
+		}
+
+		opts := cow.Options{
+			ID:             fmt.Sprintf("cow%d", i),
+			Addr:           addrs[i],
+			Peers:          peers,
+			Executor:       h.executor(i),
+			WanderInterval: defWanderInterval,
+			IdleSleep:      defIdleSleep,
+			DurationUnit:   config.TimeUnit,
+			Logger:         config.Logger,
+		}
+		if config.Loopback {
+			opts.Transport = &cow.HTTPTransport{Listen: addrs[i]}
+			opts.Admin = &cow.HTTPTransport{Listen: h.admins[i]}
+		} else {
+			opts.Transport = network.Transport(addrs[i])
+			opts.Admin = network.Transport(h.admins[i])
+		}
+		if config.Options != nil {
+			config.Options(i, &opts)
+		}
+		h.opts = append(h.opts, opts)
+		h.Cows = append(h.Cows, cow.New(opts))
+	}
+
+	for i, c := range h.Cows {
+		if err := c.Start(ctx); err != nil {
+			h.Stop()
+			return nil, fmt.Errorf("starting cow%d: %s", i, err)
+		}
+	}
+	return h, nil
+}
+
+/* Addresses for a listener of every cow, in memory named after it */
+func (h *Herd) addresses(name string) ([]string, error) {
+	addrs := make([]string, h.config.Cows)
+	for i := range addrs {
+		if !h.config.Loopback {
+			addrs[i] = fmt.Sprintf("%s%d", name, i)
+			continue
+		}
+		/* Grab a free port, the cow will listen on it */
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		addrs[i] = l.Addr().String()
+		l.Close()
+	}
+	return addrs, nil
+}
+
+/* Connect to cow i as other cows do */
+func (h *Herd) Dial(i int) (*rpc.Client, error) {
+	return h.opts[i].Transport.Dial(h.opts[i].Addr)
+}
+
+/* Connect to the CowAdmin RPCs of cow i, as cowctl does */
+func (h *Herd) DialAdmin(i int) (*rpc.Client, error) {
+	if h.opts[i].Admin == nil {
+		return nil, fmt.Errorf("cow%d does not serve CowAdmin", i)
+	}
+	return h.opts[i].Admin.Dial(h.admins[i])
+}
+
+/* Stop all cows */
+func (h *Herd) Stop() {
+	for _, c := range h.Cows {
+		c.Stop()
+	}
+}
+
+/*
+ * Executor of cow i: holds on to the item for Duration time units and
+ * records it, unless Config.Fail makes it fail or Config.Stall stall.
+ */
+func (h *Herd) executor(i int) cow.Executor {
+	return cow.ExecutorFunc(func(ctx context.Context, work cow.WorkItem) error {
+		start := time.Now()
+		if h.config.Stall != nil && h.config.Stall(i, work) {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(h.config.TimeUnit * time.Duration(work.Duration)):
+		}
+		if h.config.Fail != nil && h.config.Fail(i, work) {
+			return fmt.Errorf("cow%d failed on %s", i, work.ID)
+		}
+
+		h.mutex.Lock()
+		h.eaten = append(h.eaten, Eaten{i, work, start})
+		h.mutex.Unlock()
+		return nil
+	})
+}
+
+/*
+ * Generate n work items with durations in [0, maxDuration], reproducible
+ * for a given seed. Items are given unique IDs.
+ */
+func (h *Herd) Workload(n int, maxDuration int, seed int64) []cow.WorkItem {
+	r := rand.New(rand.NewSource(seed))
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	items := make([]cow.WorkItem, n)
+	for i := range items {
+		items[i].ID = fmt.Sprintf("item%d", h.nextID)
+		items[i].Duration = r.Intn(maxDuration + 1)
+		h.nextID++
+	}
+	return items
+}
+
+/* Sow items on cow i. Items without an ID are given one. */
+func (h *Herd) Submit(i int, items ...cow.WorkItem) {
+	for _, work := range items {
+		h.mutex.Lock()
+		if work.ID == "" {
+			work.ID = fmt.Sprintf("item%d", h.nextID)
+			h.nextID++
+		}
+		h.submitted[work.ID]++
+		h.mutex.Unlock()
+		h.Cows[i].Submit(work)
+	}
+}
+
+/* All items eaten so far, in the order they were eaten */
+func (h *Herd) Eaten() []Eaten {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]Eaten(nil), h.eaten...)
+}
+
+/* Number of items eaten by each cow */
+func (h *Herd) EatenBy() []int {
+	n := make([]int, len(h.Cows))
+	for _, e := range h.Eaten() {
+		n[e.Cow]++
+	}
+	return n
+}
+
+/* IDs of submitted items that were not eaten (yet) */
+func (h *Herd) Lost() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	count := h.countEaten()
+	var lost []string
+	for id, n := range h.submitted {
+		if count[id] < n {
+			lost = append(lost, id)
+		}
+	}
+	sort.Strings(lost)
+	return lost
+}
+
+/* IDs of items eaten more often than they were submitted */
+func (h *Herd) Duplicates() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	var dups []string
+	for id, n := range h.countEaten() {
+		if n > h.submitted[id] {
+			dups = append(dups, id)
+		}
+	}
+	sort.Strings(dups)
+	return dups
+}
+
+func (h *Herd) countEaten() map[string]int {
+	count := make(map[string]int)
+	for _, e := range h.eaten {
+		count[e.Item.ID]++
+	}
+	return count
+}
+
+/* Wait until every submitted item has been eaten, or timeout */
+func (h *Herd) WaitDrained(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		lost := h.Lost()
+		if len(lost) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("herd did not drain within %s, %d items left", timeout, len(lost))
+		}
+		time.Sleep(h.config.TimeUnit)
+	}
+}
+
+/* Check that no item was lost and none was eaten twice */
+func (h *Herd) Check() error {
+	var problems []string
+	if lost := h.Lost(); len(lost) != 0 {
+		problems = append(problems, fmt.Sprintf("items lost: %s", strings.Join(lost, ",")))
+	}
+	if dups := h.Duplicates(); len(dups) != 0 {
+		problems = append(problems, fmt.Sprintf("items eaten twice: %s", strings.Join(dups, ",")))
+	}
+	if len(problems) != 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+/*
+ * Network is an in-memory network for cow.Transport. Each Dial gets its own
+ * connection (a net.Pipe) to the RPC server registered at the address.
+ */
+type Network struct {
+	mutex   sync.Mutex
+	servers map[string]*rpc.Server
+}
+
+func NewNetwork() *Network {
+	return &Network{servers: make(map[string]*rpc.Server)}
+}
+
+/* Transport of the cow at addr */
+func (n *Network) Transport(addr string) cow.Transport {
+	return &memTransport{n, addr}
+}
+
+type memTransport struct {
+	network *Network
+	addr    string
+}
+
+func (t *memTransport) Serve(srv *rpc.Server) (io.Closer, error) {
+	t.network.mutex.Lock()
+	defer t.network.mutex.Unlock()
+	if _, ok := t.network.servers[t.addr]; ok {
+		return nil, fmt.Errorf("address %s in use", t.addr)
+	}
+	t.network.servers[t.addr] = srv
+	return t, nil
+}
+
+/* Stop serving, existing connections are closed by their clients */
+func (t *memTransport) Close() error {
+	t.network.mutex.Lock()
+	delete(t.network.servers, t.addr)
+	t.network.mutex.Unlock()
+	return nil
+}
+
+func (t *memTransport) Dial(addr string) (*rpc.Client, error) {
+	t.network.mutex.Lock()
+	srv, ok := t.network.servers[addr]
+	t.network.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("dial %s: connection refused", addr)
+	}
+	client, server := net.Pipe()
+	go srv.ServeConn(server)
+	return rpc.NewClient(client), nil
+}
@@ -0,0 +1,926 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package herdtest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shubhamat/dgo/agentcow/cow"
+)
+
+/* All work is sown on one cow, the others should forage and share the load */
+func TestHerdBalances(t *testing.T) {
+	h, err := Start(context.Background(), Config{Cows: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	h.Submit(0, h.Workload(100, 5, 1)...)
+
+	if err := h.WaitDrained(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Check(); err != nil {
+		t.Fatal(err)
+	}
+	for i, n := range h.EatenBy() {
+		if n == 0 {
+			t.Errorf("cow%d did not eat anything: %v", i, h.EatenBy())
+		}
+	}
+}
+
+func TestHerdLoopback(t *testing.T) {
+	h, err := Start(context.Background(), Config{Cows: 3, Loopback: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	h.Submit(0, h.Workload(30, 3, 2)...)
+	h.Submit(1, h.Workload(30, 3, 3)...)
+
+	if err := h.WaitDrained(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if h.EatenBy()[2] == 0 {
+		t.Errorf("cow2 did not forage anything: %v", h.EatenBy())
+	}
+}
+
+/* A lone cow eats everything itself */
+func TestSingleCow(t *testing.T) {
+	h, err := Start(context.Background(), Config{Cows: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	h.Submit(0, h.Workload(20, 1, 4)...)
+
+	if err := h.WaitDrained(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/* Items requiring a label are eaten only by the cow that has it, even if sown elsewhere */
+func TestLabels(t *testing.T) {
+	h, err := Start(context.Background(), Config{
+		Cows: 3,
+		Options: func(i int, opts *cow.Options) {
+			if i == 1 {
+				opts.Labels = []string{"gpu"}
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	items := h.Workload(40, 3, 13)
+	for i := range items {
+		if i%2 == 0 {
+			items[i].Requires = []string{"gpu"}
+		}
+	}
+	h.Submit(0, items...)
+
+	if err := h.WaitDrained(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Check(); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range h.Eaten() {
+		if len(e.Item.Requires) != 0 && e.Cow != 1 {
+			t.Errorf("%s requiring gpu eaten by cow%d", e.Item.ID, e.Cow)
+		}
+	}
+}
+
+/* Items that depend on items sown on other cows wait for them to be eaten */
+func TestDependencies(t *testing.T) {
+	h, err := Start(context.Background(), Config{Cows: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	/* A diamond per round: a, then b and c, then d, each on another cow */
+	for r := 0; r < 5; r++ {
+		id := func(s string) string { return fmt.Sprintf("%s%d", s, r) }
+		h.Submit(2, cow.WorkItem{ID: id("d"), Duration: 1, DependsOn: []string{id("b"), id("c")}})
+		h.Submit(1, cow.WorkItem{ID: id("b"), Duration: 2, DependsOn: []string{id("a")}})
+		h.Submit(0, cow.WorkItem{ID: id("c"), Duration: 1, DependsOn: []string{id("a")}})
+		h.Submit(1, cow.WorkItem{ID: id("a"), Duration: 3})
+	}
+
+	if err := h.WaitDrained(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	eaten := make(map[string]Eaten)
+	for _, e := range h.Eaten() {
+		eaten[e.Item.ID] = e
+	}
+	for _, e := range eaten {
+		for _, dep := range e.Item.DependsOn {
+			d := eaten[dep]
+			done := d.At.Add(time.Duration(d.Item.Duration) * h.config.TimeUnit)
+			if e.At.Before(done) {
+				t.Errorf("%s started before %s was eaten", e.Item.ID, dep)
+			}
+		}
+	}
+}
+
+/*
+ * Only cows waiting for an item learn that it was eaten, and every cow
+ * forgets it after HistoryTTL.
+ */
+func TestCompletedHistory(t *testing.T) {
+	const ttl = 300 * time.Millisecond
+	h, err := Start(context.Background(), Config{
+		Cows: 3,
+		Options: func(i int, opts *cow.Options) {
+			opts.HistoryTTL = ttl
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+	for _, c := range h.Cows[1:] {
+		c.SetForaging(false)
+	}
+
+	h.Submit(0, cow.WorkItem{ID: "first", Duration: 1})
+	if err := h.WaitDrained(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	h.Submit(1, cow.WorkItem{ID: "second", Duration: 1, DependsOn: []string{"first"}})
+	if err := h.WaitDrained(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !h.Cows[1].Completed("first") || h.Cows[2].Completed("first") {
+		t.Errorf("first known completed by cow1 %v and cow2 %v, want only cow1",
+			h.Cows[1].Completed("first"), h.Cows[2].Completed("first"))
+	}
+
+	time.Sleep(ttl + ttl/2)
+	for i, c := range h.Cows {
+		if c.Completed("first") {
+			t.Errorf("cow%d remembers first after %v", i, ttl)
+		}
+	}
+}
+
+/*
+ * A completion is remembered past HistoryTTL while an item blocked on
+ * another dependency needs it, and an item whose dependency never
+ * completes is given up on after DependencyTimeout.
+ */
+func TestDependencyTimeout(t *testing.T) {
+	const ttl, timeout = 200 * time.Millisecond, 1500 * time.Millisecond
+	h, err := Start(context.Background(), Config{
+		Cows: 2,
+		Options: func(i int, opts *cow.Options) {
+			opts.HistoryTTL = ttl
+			opts.DependencyTimeout = timeout
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+	for _, c := range h.Cows {
+		c.SetForaging(false)
+	}
+
+	h.Submit(0, cow.WorkItem{ID: "a", Duration: 1}, cow.WorkItem{ID: "b", Duration: 1, NotBefore: time.Now().Add(4 * ttl)})
+	h.Submit(1, cow.WorkItem{ID: "c", Duration: 1, DependsOn: []string{"a", "b"}})
+	h.Submit(1, cow.WorkItem{ID: "orphan", Duration: 1, DependsOn: []string{"never"}})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.Cows[1].DeadLetters()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("orphan not given up on, items left %v", h.Lost())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if lost := h.Lost(); len(lost) != 1 || lost[0] != "orphan" {
+		t.Errorf("lost %v, want only orphan", lost)
+	}
+	if dead := h.Cows[1].DeadLetters(); len(dead) != 1 || dead[0].Item.ID != "orphan" || !strings.Contains(dead[0].Err, "never") {
+		t.Errorf("dead letters %+v, want orphan waiting for never", dead)
+	}
+}
+
+/*
+ * Failed items are retried, on other cows too, and given up on after
+ * MaxAttempts. A retry handed to another cow does not wait for its
+ * dependencies again.
+ */
+func TestRetries(t *testing.T) {
+	h, err := Start(context.Background(), Config{
+		Cows: 3,
+		Fail: func(i int, work cow.WorkItem) bool {
+			return work.ID == "bad" || (work.ID[0] == 'f' && work.Attempts < 2)
+		},
+		Options: func(i int, opts *cow.Options) {
+			opts.RetryBackoff = 10 * time.Millisecond
+			opts.RetryElsewhere = true
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	for i := 0; i < 10; i++ {
+		root := fmt.Sprintf("root%d", i)
+		h.Submit(i%3, cow.WorkItem{ID: root, Duration: 1}, cow.WorkItem{ID: fmt.Sprintf("flaky%d", i), Duration: 1, DependsOn: []string{root}})
+	}
+	h.Submit(0, cow.WorkItem{ID: "bad", Duration: 1})
+
+	var dead []cow.DeadLetter
+	deadline := time.Now().Add(10 * time.Second)
+	for len(dead) == 0 || len(h.Lost()) > 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("items left %v, dead letters %v", h.Lost(), dead)
+		}
+		time.Sleep(10 * time.Millisecond)
+		dead = nil
+		for _, c := range h.Cows {
+			dead = append(dead, c.DeadLetters()...)
+		}
+	}
+	if lost := h.Lost(); len(lost) != 1 || lost[0] != "bad" {
+		t.Fatalf("lost %v, want only bad", lost)
+	}
+	if len(dead) != 1 || dead[0].Item.ID != "bad" || dead[0].Item.Attempts != 3 {
+		t.Fatalf("dead letters %+v, want bad after 3 attempts", dead)
+	}
+}
+
+/* Delayed items are neither eaten nor stolen before they are due */
+func TestDelayed(t *testing.T) {
+	h, err := Start(context.Background(), Config{Cows: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		delay := time.Duration(i%4) * 100 * time.Millisecond
+		h.Submit(0, cow.WorkItem{Duration: 1, NotBefore: start.Add(delay)})
+	}
+
+	if err := h.WaitDrained(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Check(); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range h.Eaten() {
+		if e.At.Before(e.Item.NotBefore) {
+			t.Errorf("%s eaten by cow%d %s early", e.Item.ID, e.Cow, e.Item.NotBefore.Sub(e.At))
+		}
+	}
+}
+
+/* Occurrences of a schedule set through CowAdmin are due Every apart, and eaten once each when due */
+func TestRecurring(t *testing.T) {
+	h, err := Start(context.Background(), Config{Cows: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	const every, want = 50 * time.Millisecond, 8
+	s := cow.Schedule{ID: "tick", Every: every, Item: cow.WorkItem{Duration: 1}}
+	peer, err := h.Dial(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.Call("CowRPC.Recur", &s, new(cow.ArgsNotUsed)); err == nil {
+		t.Error("schedules taken from other cows")
+	}
+	peer.Close()
+	client, err := h.DialAdmin(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Call("CowAdmin.Recur", &s, new(cow.ArgsNotUsed)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for len(h.Eaten()) < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d occurrences eaten, want %d", len(h.Eaten()), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := client.Call("CowAdmin.StopRecurring", &s.ID, new(cow.ArgsNotUsed)); err != nil {
+		t.Fatal(err)
+	}
+
+	eaten := h.Eaten()
+	sort.Slice(eaten, func(i, j int) bool { return eaten[i].Item.NotBefore.Before(eaten[j].Item.NotBefore) })
+	for i, e := range eaten {
+		if e.At.Before(e.Item.NotBefore) {
+			t.Errorf("%s eaten %v early", e.Item.ID, e.Item.NotBefore.Sub(e.At))
+		}
+		if i == 0 {
+			continue
+		}
+		if gap := e.Item.NotBefore.Sub(eaten[i-1].Item.NotBefore); gap != every {
+			t.Errorf("%s due %v after %s, want %v", e.Item.ID, gap, eaten[i-1].Item.ID, every)
+		}
+	}
+}
+
+/* A fair queue shares the cow between tenants by weight, whoever submitted first */
+func TestFairQueue(t *testing.T) {
+	h, err := Start(context.Background(), Config{
+		Cows:     1,
+		TimeUnit: 2 * time.Millisecond,
+		Options: func(i int, opts *cow.Options) {
+			opts.Queue = cow.NewFairQueue(map[string]int{"big": 3})
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	for _, tenant := range []string{"noisy", "big"} {
+		items := h.Workload(60, 1, 5)
+		for i := range items {
+			items[i].Duration = 1
+			items[i].Tenant = tenant
+		}
+		h.Submit(0, items...)
+	}
+
+	if err := h.WaitDrained(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Check(); err != nil {
+		t.Fatal(err)
+	}
+	big := 0
+	for _, e := range h.Eaten()[:40] {
+		if e.Item.Tenant == "big" {
+			big++
+		}
+	}
+	if big < 26 || big > 34 {
+		t.Errorf("big got %d of the first 40 items, want 30", big)
+	}
+}
+
+/* Every balancing mode spreads a load sown on one cow, the drain times compare them */
+func TestBalanceModes(t *testing.T) {
+	for _, mode := range []string{cow.BalanceReceiver, cow.BalanceSender, cow.BalanceSymmetric} {
+		t.Run(mode, func(t *testing.T) {
+			h, err := Start(context.Background(), Config{
+				Cows: 4,
+				Options: func(i int, opts *cow.Options) {
+					opts.Balance = mode
+					opts.ShedThreshold = 5
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer h.Stop()
+
+			start := time.Now()
+			h.Submit(0, h.Workload(150, 5, 6)...)
+			h.Submit(1, h.Workload(50, 5, 7)...)
+
+			if err := h.WaitDrained(10 * time.Second); err != nil {
+				t.Fatal(err)
+			}
+			if err := h.Check(); err != nil {
+				t.Fatal(err)
+			}
+			for i, n := range h.EatenBy() {
+				if n == 0 {
+					t.Errorf("cow%d did not eat anything: %v", i, h.EatenBy())
+				}
+			}
+			t.Logf("drained in %s, eaten by %v", time.Since(start).Round(time.Millisecond), h.EatenBy())
+		})
+	}
+}
+
+/*
+ * Paused cows and cows that do not forage eat nothing of another cow's
+ * load. CowAdmin is served on the admin listener only.
+ */
+func TestControl(t *testing.T) {
+	h, err := Start(context.Background(), Config{Cows: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	none := new(cow.ArgsNotUsed)
+	admin := func(i int, method string, args interface{}) {
+		client, err := h.DialAdmin(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		if err := client.Call(method, args, none); err != nil {
+			t.Fatalf("cow%d %s: %s", i, method, err)
+		}
+	}
+	admin(1, "CowAdmin.Pause", none)
+	foraging := false
+	admin(2, "CowAdmin.SetForaging", &foraging)
+	h.Cows[3].Evict("cow0")
+
+	client, err := h.Dial(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("CowAdmin.Pause", none, none); err == nil {
+		t.Error("CowAdmin served to other cows")
+	}
+	client.Close()
+
+	h.Submit(0, h.Workload(60, 3, 8)...)
+
+	if err := h.WaitDrained(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if n := h.EatenBy(); n[1] != 0 || n[2] != 0 || n[3] != 0 {
+		t.Errorf("only cow0 should have eaten: %v", n)
+	}
+	if cows := h.Cows[3].AdminStatus().Cows; len(cows) != 2 {
+		t.Errorf("cow3 still knows %v", cows)
+	}
+	if s := h.Cows[1].AdminStatus(); !s.Paused {
+		t.Errorf("cow1 not paused: %+v", s)
+	}
+}
+
+/*
+ * A herd drains despite latency, dropped RPCs, a partition and a cow
+ * crashing since it was told to through CowAdmin.
+ */
+func TestChaos(t *testing.T) {
+	chaos := cow.ChaosSettings{Latency: 2 * time.Millisecond, Jitter: 3 * time.Millisecond, RPCDrop: 0.2}
+	h, err := Start(context.Background(), Config{
+		Cows: 4,
+		Options: func(i int, opts *cow.Options) {
+			opts.RetryBackoff = 10 * time.Millisecond
+			opts.MaxAttempts = 100
+			opts.Chaos = chaos
+			switch i {
+			case 0:
+				opts.Chaos.Partition = []string{"cow3"}
+			case 3:
+				opts.Chaos.Partition = []string{"cow0"}
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	client, err := h.DialAdmin(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chaos.CrashEvery, chaos.CrashFor = 100*time.Millisecond, 50*time.Millisecond
+	if err := client.Call("CowAdmin.SetChaos", &chaos, new(cow.ArgsNotUsed)); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	h.Submit(0, h.Workload(100, 5, 9)...)
+
+	if err := h.WaitDrained(20 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if n := h.EatenBy(); n[3] != 0 {
+		t.Errorf("cow3 ate across the partition: %v", n)
+	}
+	if stats := h.Cows[1].Stats(); stats.Crashed == 0 || stats.Failed != 0 {
+		t.Errorf("cow1 crashed %d times while eating, failed %d attempts", stats.Crashed, stats.Failed)
+	}
+
+	/* cow0 refuses cow3 whatever it calls, and steal requests cow3 forwards */
+	client, err = h.Dial(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	from := &cow.Caller{From: "cow3"}
+	for method, args := range map[string]interface{}{
+		"CowRPC.GetQueueLen":     from,
+		"CowRPC.GetDeadLetters":  from,
+		"CowRPC.GetStealableLen": &cow.StealArgs{Thief: "cow3"},
+		"CowRPC.GetWorkItem":     &cow.StealArgs{Thief: "cow1", Path: []string{"cow2", "cow3"}},
+	} {
+		if err := client.Call(method, args, new(cow.WorkItem)); err == nil || !strings.Contains(err.Error(), "partitioned") {
+			t.Errorf("%s from cow3: %v", method, err)
+		}
+	}
+}
+
+/* Stop does not wait out latency injected into connections */
+func TestStopDuringLatency(t *testing.T) {
+	h, err := Start(context.Background(), Config{
+		Cows: 2,
+		Options: func(i int, opts *cow.Options) {
+			opts.Chaos.Latency = time.Minute
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	h.Stop()
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("stopping took %v", took)
+	}
+}
+
+/*
+ * With the latency policy a far away cow does not steal items it would eat
+ * later than their owner, with the max policy it does. cow0 eats a few
+ * short items and is paused, so its queue looks short to the far cow2;
+ * the near cow1 eats the long items sown on it one at a time.
+ */
+func TestLatencyAware(t *testing.T) {
+	for _, policy := range []string{cow.ForageLatency, cow.ForageMax} {
+		t.Run(policy, func(t *testing.T) {
+			h, err := Start(context.Background(), Config{
+				Cows: 3,
+				Options: func(i int, opts *cow.Options) {
+					opts.ForagePolicy = policy
+					if i == 2 {
+						opts.Chaos.Latency = 200 * time.Millisecond
+					}
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer h.Stop()
+
+			h.Cows[0].SetForageable(false)
+			h.Submit(0, h.Workload(3, 1, 10)...)
+			if err := h.WaitDrained(5 * time.Second); err != nil {
+				t.Fatal(err)
+			}
+			h.Cows[0].Pause()
+			h.Cows[0].SetForageable(true)
+
+			const items = 8
+			long := h.Workload(items, 1, 11)
+			for i := range long {
+				long[i].Duration = 20
+			}
+			h.Submit(0, long...)
+			/* The near cow leaves the last item, it is not worth a steal */
+			for deadline := time.Now().Add(10 * time.Second); len(h.Eaten()) < 3+items-1; {
+				if time.Now().After(deadline) {
+					t.Fatalf("cows ate %v", h.EatenBy())
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			h.Cows[0].Resume()
+			if err := h.WaitDrained(5 * time.Second); err != nil {
+				t.Fatal(err)
+			}
+			if err := h.Check(); err != nil {
+				t.Fatal(err)
+			}
+
+			n := h.EatenBy()
+			if n[1] == 0 {
+				t.Errorf("the near cow did not forage: %v", n)
+			}
+			if policy == cow.ForageLatency && n[2] != 0 {
+				t.Errorf("the far cow foraged: %v", n)
+			}
+			if policy == cow.ForageMax && n[2] == 0 {
+				t.Errorf("the far cow did not forage: %v", n)
+			}
+		})
+	}
+}
+
+/* A herd with small views, every cow first knowing only the next one, drains a single busy cow */
+func TestPartialViews(t *testing.T) {
+	const cows, view = 12, 3
+	h, err := Start(context.Background(), Config{
+		Cows: cows,
+		Options: func(i int, opts *cow.Options) {
+			opts.Peers = []string{fmt.Sprintf("cow%d", (i+1)%cows)}
+			opts.ViewSize = view
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	h.Submit(0, h.Workload(150, 5, 9)...)
+
+	if err := h.WaitDrained(20 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Check(); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range h.Cows {
+		if known := c.AdminStatus().Cows; len(known) > view {
+			t.Errorf("cow%d knows %d cows: %v", i, len(known), known)
+		}
+	}
+}
+
+/*
+ * A steal request is forwarded to a cow the thief does not know: cow2
+ * knows only cow1, which has nothing and does not eat, cow1 knows cow0.
+ */
+func TestForwarding(t *testing.T) {
+	h, err := Start(context.Background(), Config{
+		Cows: 3,
+		Options: func(i int, opts *cow.Options) {
+			opts.Peers = map[int][]string{1: {"cow0"}, 2: {"cow1"}}[i]
+			opts.MaxHops = 1
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+	h.Cows[1].SetForaging(false)
+
+	h.Submit(0, h.Workload(40, 5, 12)...)
+
+	if err := h.WaitDrained(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if n := h.EatenBy(); n[1] != 0 || n[2] == 0 {
+		t.Errorf("only cow0 and cow2 should have eaten: %v", n)
+	}
+	if h.Cows[1].Stats().Forwarded == 0 {
+		t.Error("cow1 forwarded no steal request")
+	}
+}
+
+/* A copy of an item stuck on one cow is eaten by another, which cancels the stuck one */
+func TestSpeculation(t *testing.T) {
+	var stalled atomic.Bool
+	h, err := Start(context.Background(), Config{
+		Cows: 2,
+		Stall: func(i int, work cow.WorkItem) bool {
+			return work.ID == "slow" && stalled.CompareAndSwap(false, true)
+		},
+		Options: func(i int, opts *cow.Options) {
+			opts.Speculate = 2
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	h.Submit(0, cow.WorkItem{ID: "slow", Duration: 5})
+
+	if err := h.WaitDrained(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Check(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		var speculated, overtaken, running int
+		for _, c := range h.Cows {
+			speculated += c.Stats().Speculated
+			overtaken += c.Stats().Overtaken
+			running += len(c.Running())
+		}
+		if speculated == 1 && overtaken == 1 && running == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d copies, %d runs cancelled, %d still running, want 1, 1 and 0", speculated, overtaken, running)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+/* Items are withdrawn from the queue of the cow they were shed to, from the held items and while running */
+func TestCancel(t *testing.T) {
+	h, err := Start(context.Background(), Config{
+		Cows: 2,
+		Stall: func(i int, work cow.WorkItem) bool {
+			return work.ID == "stuck"
+		},
+		Options: func(i int, opts *cow.Options) {
+			if i == 0 {
+				opts.Balance = cow.BalanceSender
+				opts.ShedThreshold = 3
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+	cow0, cow1 := h.Cows[0], h.Cows[1]
+	cow0.Pause()
+	cow1.Pause()
+
+	var ids []string
+	for i := 0; i < 5; i++ {
+		ids = append(ids, fmt.Sprintf("a%d", i))
+		h.Submit(0, cow.WorkItem{ID: ids[i], Duration: 1})
+	}
+	h.Submit(0, cow.WorkItem{ID: "later", Duration: 1, NotBefore: time.Now().Add(time.Hour)})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for cow1.QueueLen() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("cow1 was shed %d items, want 2", cow1.QueueLen())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	where := make(map[cow.CancelReply]int)
+	for _, id := range ids {
+		where[cow0.Cancel(id)]++
+	}
+	if where[cow.CancelReply{Cow: "cow0", State: cow.CancelQueued}] != 3 || where[cow.CancelReply{Cow: "cow1", State: cow.CancelQueued}] != 2 {
+		t.Errorf("cancelled %v, want 3 queued on cow0 and 2 on cow1", where)
+	}
+	if reply := cow0.Cancel("later"); reply.State != cow.CancelHeld {
+		t.Errorf("cancelling a held item: %+v", reply)
+	}
+	if cow0.QueueLen()+cow1.QueueLen()+cow0.HeldLen() != 0 {
+		t.Error("cancelled items are still queued or held")
+	}
+
+	cow0.Resume()
+	h.Submit(0, cow.WorkItem{ID: "stuck", Duration: 1})
+	for len(cow0.Running()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("cow0 did not start eating")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if reply := cow0.Cancel("stuck"); reply.State != cow.CancelRunning {
+		t.Errorf("cancelling a running item: %+v", reply)
+	}
+	for len(cow0.Running()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the executor was not interrupted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	/* Cancelling an ID no cow had does not drop an item sown later */
+	if reply := cow0.Cancel("unknown"); reply.State != "" {
+		t.Errorf("cancelling an unknown item: %+v", reply)
+	}
+	h.Submit(0, cow.WorkItem{ID: "unknown", Duration: 1})
+	for len(h.Eaten()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("unknown was not eaten")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(h.Eaten()); n != 1 || cow0.Stats().Failed != 0 || cow0.Stats().Cancelled != 5 {
+		t.Errorf("%d eaten, stats %+v, want unknown eaten and 5 cancelled", n, cow0.Stats())
+	}
+}
+
+/* The cow a job is submitted to tracks it across the herd and is told when it finishes */
+func TestJobs(t *testing.T) {
+	finished := make(chan cow.JobStatus, 1)
+	h, err := Start(context.Background(), Config{
+		Cows: 3,
+		Fail: func(i int, work cow.WorkItem) bool {
+			return work.ID == "nightly/7"
+		},
+		Options: func(i int, opts *cow.Options) {
+			opts.MaxAttempts = 2
+			opts.RetryBackoff = 10 * time.Millisecond
+			opts.OnJobDone = func(s cow.JobStatus) { finished <- s }
+			/* Progress reports are lost, and sent again */
+			if i != 0 {
+				opts.Chaos.RPCDrop = 0.5
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	items := make([]cow.WorkItem, 30)
+	for i := range items {
+		items[i].Duration = 5
+	}
+	if err := h.Cows[0].SubmitJob("nightly", items); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Cows[0].SubmitJob("nightly", items); err == nil {
+		t.Error("a job was submitted twice")
+	}
+	if reply := h.Cows[0].Cancel("nightly/29"); reply.State == cow.CancelEaten {
+		t.Fatalf("nightly/29 was eaten before it could be cancelled")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	status, err := h.Cows[0].WaitJob(ctx, "nightly")
+	if err != nil {
+		t.Fatalf("%s: %+v", err, status)
+	}
+	want := cow.JobStatus{ID: "nightly", Items: 30, Done: 28, Failed: 1, Cancelled: 1}
+	got := status
+	got.Submitted, got.Finished = time.Time{}, time.Time{}
+	if got != want || status.Finished.IsZero() {
+		t.Errorf("job finished as %+v, want %+v", status, want)
+	}
+	select {
+	case s := <-finished:
+		if s != status {
+			t.Errorf("OnJobDone got %+v, want %+v", s, status)
+		}
+	default:
+		t.Error("OnJobDone was not called")
+	}
+	if n := h.EatenBy(); n[1]+n[2] == 0 {
+		t.Errorf("no other cow ate items of the job: %v", n)
+	}
+}
+
+/* A finished job is forgotten after HistoryTTL */
+func TestJobForgotten(t *testing.T) {
+	const ttl = 200 * time.Millisecond
+	h, err := Start(context.Background(), Config{
+		Cows: 1,
+		Options: func(i int, opts *cow.Options) {
+			opts.HistoryTTL = ttl
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+
+	if err := h.Cows[0].SubmitJob("once", make([]cow.WorkItem, 2)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if status, err := h.Cows[0].WaitJob(ctx, "once"); err != nil {
+		t.Fatalf("%s: %+v", err, status)
+	}
+	time.Sleep(ttl + ttl/2)
+	if status, ok := h.Cows[0].Job("once"); ok {
+		t.Errorf("job remembered after %v: %+v", ttl, status)
+	}
+}
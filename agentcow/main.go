@@ -0,0 +1,391 @@
+/* (c) 2017  Shubham Mankhand  <shubham.mankhand@gmail.com> */
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shubhamat/dgo/agentcow/cow"
+)
+
+/* -1 implies sow thread will keep sowing */
+const defWorkItems = -1
+const defWorkItemsOutFile = 100
+const defMaxWorkDuration = 11
+const defMaxWorkCost = 101
+const defMaxSowSleep = 6
+const defBurstSize = 10
+const defBurstPeriod = 30
+
+const defIface = "wlan0"
+
+var myip string
+var mycow *cow.Cow
+var logger *cow.Logger
+var sowlog *slog.Logger
+var myrequires []string
+var startTime time.Time
+
+var configFile = flag.String("config", "", "Configuration file (JSON) with flag values, flags given on the command line override it")
+var launchSow = flag.Bool("sow", false, "Start sow thread")
+var iface = flag.String("iface", defIface, "Interface used for sending data")
+var outfile = flag.String("sow-of", "", "Output file for storing workitems")
+var infile = flag.String("eat-if", "", "Input file  for filling work queue with work items")
+var workItems = flag.Int("work-items", defWorkItems, "Number of work items to be generated by sow thread")
+var maxWorkDuration = flag.Int("max-work-duration", defMaxWorkDuration, "Max duration of work items generated by sow thread")
+var maxWorkCost = flag.Int("max-work-cost", defMaxWorkCost, "Max cost (exclusive) of work items generated by sow thread")
+var maxSowSleep = flag.Int("max-sow-sleep", defMaxSowSleep, "Max seconds (exclusive) sow thread sleeps between work items, used with -arrival=uniform or poisson")
+var arrivalDist = flag.String("arrival", arrival_uniform, "Arrival distribution of work items generated by sow thread: uniform, poisson or burst")
+var durationDist = flag.String("duration-dist", duration_uniform, "Duration distribution of generated work items: uniform, exponential, pareto or bimodal")
+var burstSize = flag.Int("burst-size", defBurstSize, "Number of work items in a burst, used with -arrival=burst")
+var burstPeriod = flag.Int("burst-period", defBurstPeriod, "Seconds between bursts, used with -arrival=burst")
+var seed = flag.Int64("seed", 0, "Seed for generating work items, 0 uses the current time")
+var tlsCert = flag.String("tls-cert", "", "Certificate of this cow, used for mutual TLS with other cows")
+var tlsKey = flag.String("tls-key", "", "Private key for -tls-cert")
+var tlsCA = flag.String("tls-ca", "", "CA certificate that signs the certificates of all cows in the herd")
+var herdID = flag.String("herd", cow.DefaultHerd, "Name of the herd, cows only join cows of the same herd")
+var cowID = flag.String("cow-id", "", "ID of this cow, unique within the herd. Defaults to hostname:pid")
+var labels = flag.String("labels", "", "Comma separated capability labels of this cow")
+var requires = flag.String("requires", "", "Comma separated labels required by work items generated by sow")
+var tenant = flag.String("tenant", "", "Tenant of work items generated by sow")
+var fair = flag.Bool("fair", false, "Share the cow fairly between tenants instead of eating items first come first served")
+var tenantWeights = flag.String("tenant-weights", "", "Comma separated tenant=weight shares used with -fair, other tenants weigh 1")
+var ipv6 = flag.Bool("ipv6", false, "Use IPv6 even if the interface has an IPv4 address")
+var port = flag.String("port", cow.DefaultPort, "Port used for RPC and discovery")
+var adminAddr = flag.String("admin", cow.DefaultAdminAddr, "Address (host:port) the CowAdmin RPCs of cowctl are served on, empty means not served. Anyone who can reach it controls the cow")
+var peers = flag.String("peers", "", "Comma separated RPC addresses (host:port) of cows to join without discovery")
+var balance = flag.String("balance", cow.BalanceReceiver, "Balancing mode: receiver (idle cows forage), sender (busy cows shed) or symmetric")
+var shedThreshold = flag.Int("shed-threshold", 10, "Queue length above which a cow sheds work items, used with -balance=sender or symmetric")
+var foragePolicy = flag.String("forage-policy", cow.ForageMax, "Which cow to steal from: max, random or latency (the cow where items wait longest beyond the round trip time)")
+var viewSize = flag.Int("view-size", 0, "Most cows this cow knows at once, swapping samples with them, 0 means all")
+var maxHops = flag.Int("max-hops", 0, "How often a steal request is forwarded towards busier cows, -1 means never. Defaults to 3 with -view-size")
+var wanderInterval = flag.Duration("wander-interval", time.Second, "How often the queue length of other cows is fetched")
+var announceInterval = flag.Duration("announce-interval", time.Second, "How often this cow announces itself to the herd")
+var idleSleep = flag.Duration("idle-sleep", 100*time.Millisecond, "How long eat thread waits when there is no work")
+var dialTimeout = flag.Duration("dial-timeout", 5*time.Second, "Timeout for connecting to other cows")
+var maxAttempts = flag.Int("max-attempts", 3, "Attempts at eating a work item before it is put on the dead-letter list")
+var retryBackoff = flag.Duration("retry-backoff", time.Second, "Wait before retrying a failed work item, doubled for every retry")
+var speculate = flag.Float64("speculate", 0, "Start a copy of an item another cow has been eating this many times longer than its duration, 0 means never")
+var retryElsewhere = flag.Bool("retry-elsewhere", false, "Hand failed work items to another cow to retry")
+var herdSecret = flag.String("herd-secret", "", "Shared secret used to authenticate discovery beacons")
+var eventLog = flag.String("event-log", "", "Append queue events to this file (JSONL), see cmd/herdlog")
+var logLevel = flag.String("log-level", "info", "Log level: debug, info, warn or error")
+var logJSON = flag.Bool("log-json", false, "Log in JSON instead of text")
+var subsysLogLevels = make(map[string]*string)
+var chaos cow.ChaosSettings
+
+var chaosUsage = map[string]string{
+	"latency":     "Latency added to every connection to another cow",
+	"jitter":      "Random latency up to this much added on top of -chaos-latency",
+	"rpc-drop":    "Fraction (0 to 1) of connections to other cows that fail",
+	"beacon-drop": "Fraction (0 to 1) of discovery beacons dropped",
+	"partition":   "Comma separated RPC addresses of cows this cow cannot talk to",
+	"crash-every": "Crash the eat thread this often",
+	"crash-for":   "Time the eat thread stays down after a crash",
+}
+
+/* A -chaos-<name> flag, setting the name setting of chaos */
+type chaosFlag string
+
+func (f chaosFlag) String() string     { return chaos.Get(string(f)) }
+func (f chaosFlag) Set(v string) error { return chaos.Set(string(f), v) }
+
+func init() {
+	for _, subsys := range cow.Subsystems {
+		subsysLogLevels[subsys] = flag.String("log-"+subsys, "", "Log level of the "+subsys+" subsystem, defaults to -log-level")
+	}
+	for _, name := range cow.ChaosNames {
+		flag.Var(chaosFlag(name), "chaos-"+name, chaosUsage[name]+", for fault injection experiments")
+	}
+}
+
+func main() {
+
+	initAll()
+
+	if err := mycow.Start(context.Background()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(3)
+	}
+
+	if *launchSow {
+		go sow()
+	}
+
+	/* On a ctrl+c print report and exit, on a SIGHUP reload the configuration file */
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGHUP)
+	for sig := range sigchan {
+		if sig == syscall.SIGHUP {
+			reloadConfig()
+			continue
+		}
+		printReportAndExit()
+	}
+}
+
+func initAll() {
+
+	startTime = time.Now()
+
+	/* Process the command line flags, and the configuration file they point to */
+	flag.Parse()
+	if *configFile != "" {
+		if err := loadConfig(*configFile, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Error in loading configuration file:%s\n%s\n", *configFile, err)
+			os.Exit(1)
+		}
+	}
+
+	if *launchSow && *outfile != "" {
+		fmt.Fprintf(os.Stderr, "-sow and -sow-of cannot be used together\n")
+		os.Exit(1)
+	}
+
+	if *launchSow && *infile != "" {
+		fmt.Fprintf(os.Stderr, "-sow and -eat-if cannot be used together\n")
+		os.Exit(1)
+	}
+
+	if *outfile != "" && *infile != "" {
+		fmt.Fprintf(os.Stderr, "-sow-of and -eat-if cannot be used together\n")
+		os.Exit(1)
+	}
+
+	if !*launchSow && *outfile == "" && *workItems != -1 {
+		fmt.Fprintf(os.Stderr, "-work-items should be used with -sow or -sow-of\n")
+		os.Exit(1)
+	}
+
+	if err := checkReloadable(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	/* Accept both 23432 and :23432 */
+	listen := ":" + strings.TrimPrefix(*port, ":")
+
+	logger = cow.NewLogger(cow.LogConfig{JSON: *logJSON})
+	logger.SetLevels(logLevels())
+	sowlog = logger.Subsystem(cow.SubsysSow)
+
+	myrequires = cow.ParseLabels(*requires)
+
+	tlsConfig, err := cow.LoadTLSConfig(*tlsCert, *tlsKey, *tlsCA)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error in loading TLS configuration\n%s\n", err)
+		os.Exit(1)
+	}
+
+	rand.Seed(time.Now().UTC().UnixNano())
+	if *seed == 0 {
+		*seed = time.Now().UTC().UnixNano()
+	}
+	sowRand = rand.New(rand.NewSource(*seed))
+
+	if *outfile != "" {
+		if *workItems == -1 {
+			*workItems = defWorkItemsOutFile
+		}
+		sowToFile(*outfile)
+	}
+
+	/* Setup network properties */
+	myipaddr, err := cow.InterfaceAddr(*iface, *ipv6)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error in getting address for interface:%s\n%s\n", *iface, err)
+		fmt.Println("You must specify an interface.  Usage:  cow -iface <InterfaceName>")
+		os.Exit(1)
+	}
+	myip = myipaddr.IP.String()
+
+	discovery, err := cow.NewUDPDiscovery(*iface, myipaddr, listen, []byte(*herdSecret))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var queue cow.Queue
+	if *fair {
+		weights, err := cow.ParseTenantWeights(*tenantWeights)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		queue = cow.NewFairQueue(weights)
+	}
+
+	opts := cow.Options{
+		ID:               *cowID,
+		Herd:             *herdID,
+		Addr:             cow.RPCAddr(myip, listen),
+		Labels:           cow.ParseLabels(*labels),
+		Peers:            cow.ParseLabels(*peers),
+		Transport:        &cow.HTTPTransport{Listen: listen, TLS: tlsConfig, DialTimeout: *dialTimeout},
+		Discovery:        discovery,
+		Balance:          *balance,
+		ShedThreshold:    *shedThreshold,
+		ForagePolicy:     *foragePolicy,
+		ViewSize:         *viewSize,
+		MaxHops:          *maxHops,
+		WanderInterval:   *wanderInterval,
+		AnnounceInterval: *announceInterval,
+		IdleSleep:        *idleSleep,
+		Queue:            queue,
+		MaxAttempts:      *maxAttempts,
+		RetryBackoff:     *retryBackoff,
+		RetryElsewhere:   *retryElsewhere,
+		Speculate:        *speculate,
+		Chaos:            chaos,
+		Tunables:         tunables(),
+		Logger:           logger,
+	}
+
+	if *adminAddr != "" {
+		opts.Admin = &cow.HTTPTransport{Listen: *adminAddr, TLS: tlsConfig}
+	}
+
+	if *eventLog != "" {
+		file, err := os.OpenFile(*eventLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		opts.EventLog = file
+	}
+
+	/* When processing data off a file, print a report on time taken to process all items. */
+	if *infile != "" {
+		opts.OnEmpty = printReportAndExit
+	}
+
+	mycow = cow.New(opts)
+
+	if *infile != "" {
+		eatFromFile(*infile)
+	}
+
+	sowlog = sowlog.With("cow", mycow.ID())
+	mycow.Log(cow.SubsysMoo).Info("initialized cow", "ip", myip, "herd", *herdID, "discovery", discovery.Target())
+}
+
+/* Log levels set by -log-level and -log-<subsystem>, see checkReloadable */
+func logLevels() (slog.Level, map[string]slog.Level) {
+	var level slog.Level
+	level.UnmarshalText([]byte(*logLevel))
+	levels := make(map[string]slog.Level)
+	for subsys, l := range subsysLogLevels {
+		var sl slog.Level
+		if *l != "" && sl.UnmarshalText([]byte(*l)) == nil {
+			levels[subsys] = sl
+		}
+	}
+	return level, levels
+}
+
+func eatFromFile(filename string) {
+	log := mycow.Log(cow.SubsysEat)
+	log.Info("filling work queue from file", "file", filename)
+
+	work := cow.WorkItem{}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	dec := gob.NewDecoder(file)
+	n := 0
+	for dec.Decode(&work) == nil {
+		if work.ID == "" {
+			work.ID = fmt.Sprintf("%s-file%d", mycow.ID(), n)
+		}
+		mycow.Submit(work)
+		n++
+		log.Debug("added work item", "n", n, "duration", work.Duration, "qlen", mycow.QueueLen())
+		/* gob leaves fields missing in the stream untouched, start afresh */
+		work = cow.WorkItem{}
+	}
+	*workItems = n
+}
+
+/* Print Report */
+func printReportAndExit() {
+	delta := time.Since(startTime)
+	stats := mycow.Stats()
+	fmt.Printf("\n[COW:%s] Took %d seconds to process %d local items and %d remote items\n",
+		myip, int(delta.Seconds()), stats.Local, stats.Remote)
+	if dead := mycow.DeadLetters(); len(dead) != 0 {
+		fmt.Printf("[COW:%s] %d failed attempts, gave up on %d items\n", myip, stats.Failed, len(dead))
+	}
+	if stats.Crashed != 0 {
+		fmt.Printf("[COW:%s] lost %d items to chaos crashes and ate them again\n", myip, stats.Crashed)
+	}
+	if stats.Speculated != 0 || stats.Overtaken != 0 {
+		fmt.Printf("[COW:%s] started %d copies of stragglers, %d items were eaten elsewhere first\n", myip, stats.Speculated, stats.Overtaken)
+	}
+	os.Exit(0)
+}
+
+/* Load Generators */
+
+func sow() {
+	sowlog.Info("launched thread", "arrival", *arrivalDist, "duration", *durationDist, "seed", *seed)
+	n := 0
+	for {
+		/* Sleep for a time picked by the arrival distribution */
+		reloadMutex.Lock()
+		sleep := nextArrival(n)
+		reloadMutex.Unlock()
+		time.Sleep(sleep)
+
+		reloadMutex.Lock()
+		Duration := nextDuration(*maxWorkDuration)
+		Cost := sowRand.Intn(*maxWorkCost)
+		reloadMutex.Unlock()
+		work := cow.WorkItem{Duration: Duration, Cost: Cost, Origin: cow.OriginLocal, Requires: myrequires, Tenant: *tenant}
+		work.ID = fmt.Sprintf("%s-%d", mycow.ID(), n)
+		mycow.Submit(work)
+		sowlog.Info("added work item", "n", n, "duration", work.Duration, "qlen", mycow.QueueLen())
+		n++
+		if *workItems != -1 && n > *workItems {
+			sowlog.Info("exiting thread")
+			return
+		}
+	}
+
+}
+
+func sowToFile(filename string) {
+	sowlog.Info("sowing work items to file", "items", *workItems, "file", filename, "duration", *durationDist, "seed", *seed)
+
+	file, err := os.Create(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	enc := gob.NewEncoder(file)
+
+	for n := 0; n < *workItems; n++ {
+		Duration := nextDuration(*maxWorkDuration)
+		Cost := sowRand.Intn(*maxWorkCost)
+		work := cow.WorkItem{Duration: Duration, Cost: Cost, Origin: cow.OriginLocal, Requires: myrequires, Tenant: *tenant}
+		enc.Encode(work)
+		sowlog.Debug("added work item", "n", n, "duration", work.Duration)
+	}
+
+	file.Close()
+
+	os.Exit(0)
+}